@@ -5,9 +5,10 @@ go 1.12
 require (
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/emersion/go-imap v1.0.4
-	github.com/mattermost/mattermost-server/v5 v5.20.0
+	github.com/mattermost/mattermost-server/v5 v5.26.0
 	github.com/mholt/archiver/v3 v3.3.0
 	github.com/pkg/errors v0.9.1
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344
 	golang.org/x/sys v0.0.0-20220928140112-f11e5e49a4ec // indirect
 )
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/blang/semver"
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
 )
 
-const minimumServerVersion = "5.4.0"
+const (
+	minimumServerVersion = "5.26.0"
+
+	botUsername    = "mailermost"
+	botDisplayName = "Mailermost"
+	botDescription = "Created by the Mailermost plugin."
+)
 
 func (p *Plugin) checkServerVersion() error {
 	serverVersion, err := semver.Parse(p.API.GetServerVersion())
@@ -27,21 +36,118 @@ func (p *Plugin) checkServerVersion() error {
 
 // OnActivate is invoked when the plugin is activated.
 //
-// This demo implementation logs a message to the demo channel whenever the plugin is activated.
+// It starts polling the configured mailbox.
 func (p *Plugin) OnActivate() error {
 	if err := p.checkServerVersion(); err != nil {
 		return err
 	}
 
+	botUserID, err := p.Helpers.EnsureBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: botDisplayName,
+		Description: botDescription,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot")
+	}
+	p.botUserID = botUserID
+	p.metrics = mailermost.NewMetrics()
+	p.followedThreads = mailermost.NewFollowedThreads(p.API)
+
+	if err = p.API.RegisterCommand(getCommand()); err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+
+	return p.restartPollers()
+}
+
+// OnDeactivate is invoked when the plugin is deactivated. It waits for the pollers to finish the
+// message they are processing, if any.
+func (p *Plugin) OnDeactivate() error {
+	p.restartLock.Lock()
+	defer p.restartLock.Unlock()
+
+	p.stopRunningPollers()
+
+	return nil
+}
+
+// restartPollers stops the running pollers, if any, and starts one for each mailbox account in
+// the active configuration.
+func (p *Plugin) restartPollers() error {
+	p.restartLock.Lock()
+	defer p.restartLock.Unlock()
+
 	configuration := p.getConfiguration()
 
-	poller, err := mailermost.NewPoller(p.API, configuration.Server, configuration.Security, configuration.Password, configuration.PollingInterval)
+	accounts, err := configuration.accounts(*p.API.GetConfig().EmailSettings.ReplyToAddress)
 	if err != nil {
-		return errors.Wrap(err, "failed to create poller")
+		return errors.Wrap(err, "invalid mailbox accounts")
 	}
-	p.Poller = poller
 
-	go p.Poller.Poll()
+	settings := configuration.settings()
+
+	pollers := make([]*mailermost.Poller, 0, len(accounts))
+	for _, account := range accounts {
+		var poller *mailermost.Poller
+		poller, err = mailermost.NewPoller(p.API, p.botUserID, account, settings, p.metrics)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create poller for account %q", account.Name)
+		}
+		pollers = append(pollers, poller)
+	}
+
+	p.stopRunningPollers()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, poller := range pollers {
+		wg.Add(1)
+		go func(poller *mailermost.Poller) {
+			defer wg.Done()
+			poller.Poll(ctx)
+		}(poller)
+	}
+
+	p.pollerLock.Lock()
+	defer p.pollerLock.Unlock()
+
+	p.Pollers = pollers
+	p.stopPollers = func() {
+		cancel()
+		wg.Wait()
+	}
 
 	return nil
 }
+
+// stopRunningPollers stops the running pollers, if any. The caller must hold restartLock.
+// pollerLock is not held while waiting for the pollers, so that getPollers does not wait for
+// an IMAP command in progress.
+func (p *Plugin) stopRunningPollers() {
+	p.pollerLock.Lock()
+	stop := p.stopPollers
+	p.stopPollers = nil
+	p.Pollers = nil
+	p.pollerLock.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
+
+// getPollers returns the running pollers.
+func (p *Plugin) getPollers() []*mailermost.Poller {
+	p.pollerLock.Lock()
+	defer p.pollerLock.Unlock()
+
+	return append([]*mailermost.Poller(nil), p.Pollers...)
+}
+
+// isPolling reports whether the pollers are currently running.
+func (p *Plugin) isPolling() bool {
+	p.pollerLock.Lock()
+	defer p.pollerLock.Unlock()
+
+	return p.stopPollers != nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+func TestRestartPollers(t *testing.T) {
+	config := &model.Config{}
+	config.SetDefaults()
+
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(config)
+	api.On("KVGet", mock.Anything).Return(nil, nil).Maybe()
+
+	plugin := &Plugin{}
+	plugin.SetAPI(api)
+	plugin.setConfiguration(&configuration{
+		Server:          "imap.example.org:993",
+		Security:        "ssl",
+		Email:           "replies@example.org",
+		PollingInterval: 60,
+	})
+
+	require.NoError(t, plugin.restartPollers())
+	pollers := plugin.getPollers()
+	assert.Len(t, pollers, 1)
+	assert.True(t, plugin.isPolling())
+
+	// Restarting replaces the pollers.
+	require.NoError(t, plugin.restartPollers())
+	assert.Len(t, plugin.getPollers(), 1)
+	assert.False(t, plugin.getPollers()[0] == pollers[0])
+
+	// An invalid configuration keeps the running pollers.
+	plugin.setConfiguration(&configuration{Email: "replies@example.org", PollingInterval: 60})
+	assert.Error(t, plugin.restartPollers())
+	assert.True(t, plugin.isPolling())
+
+	require.NoError(t, plugin.OnDeactivate())
+	assert.Empty(t, plugin.getPollers())
+	assert.False(t, plugin.isPolling())
+}
+
+func TestStopPollersDoesNotBlockReaders(t *testing.T) {
+	plugin := &Plugin{}
+
+	// The pollers are stuck in an IMAP command.
+	stuck := make(chan struct{})
+	plugin.Pollers = []*mailermost.Poller{{}}
+	plugin.stopPollers = func() { <-stuck }
+
+	deactivated := make(chan struct{})
+	go func() {
+		defer close(deactivated)
+		assert.NoError(t, plugin.OnDeactivate())
+	}()
+
+	read := make(chan []*mailermost.Poller)
+	go func() {
+		for plugin.isPolling() {
+			time.Sleep(time.Millisecond)
+		}
+		read <- plugin.getPollers()
+	}()
+
+	select {
+	case pollers := <-read:
+		assert.Empty(t, pollers)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading the pollers waited for them to stop")
+	}
+
+	select {
+	case <-deactivated:
+		t.Fatal("deactivation did not wait for the pollers to stop")
+	default:
+	}
+
+	close(stuck)
+	<-deactivated
+}
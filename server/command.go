@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+const (
+	commandTrigger = "mailermost"
+
+	commandAuditLimit   = 50
+	commandHistoryLimit = 20
+)
+
+const commandHelp = "* `/mailermost history` - Show what became of your recent email replies\n" +
+	"* `/mailermost mute` - Stop posting your email replies to Mattermost\n" +
+	"* `/mailermost unmute` - Post your email replies to Mattermost again\n" +
+	"* `/mailermost alias add [email]` - Post email replies you send from another address, after verifying it\n" +
+	"* `/mailermost alias verify [code]` - Verify an address with the code emailed to it\n" +
+	"* `/mailermost alias remove [email]` - Stop posting email replies from an address\n" +
+	"* `/mailermost alias list` - List the addresses your email replies are posted from\n" +
+	"* `/mailermost channel-address [new|revoke]` - Show, create or delete the email address that starts new posts in this channel (channel admins)\n" +
+	"* `/mailermost direct-address [new]` - Show or replace the email address that sends you direct messages\n"
+
+const commandAdminHelp = "* `/mailermost status` - Show the last poll time, pending emails and errors of each mailbox\n" +
+	"* `/mailermost test-connection` - Log into each mailbox and select its folders\n" +
+	"* `/mailermost poll-now` - Check the mailboxes right away\n" +
+	"* `/mailermost history @username` - Show what became of the recent email replies of a user\n" +
+	"* `/mailermost audit [@username] [since] [until]` - Show what became of recent inbound emails, optionally of a single user and between two dates given as YYYY-MM-DD\n"
+
+func getCommand() *model.Command {
+	return &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Mailermost",
+		Description:      "Manage replies to notification emails.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: history, mute, unmute, alias, channel-address, direct-address, status, test-connection, poll-now, audit, help",
+		AutoCompleteHint: "[command]",
+	}
+}
+
+func commandResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         text,
+	}
+}
+
+// ExecuteCommand executes the /mailermost command.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return p.executeHelp(args), nil
+	}
+
+	isAdmin := p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM)
+	adminOnly := commandResponse(fmt.Sprintf("Only system admins can use `/mailermost %s`.", fields[1]))
+
+	switch fields[1] {
+	case "history":
+		return p.executeHistory(args, fields[2:], isAdmin), nil
+	case "mute":
+		return p.executeMute(args, true), nil
+	case "unmute":
+		return p.executeMute(args, false), nil
+	case "alias":
+		return p.executeAlias(args, fields[2:]), nil
+	case "channel-address":
+		return p.executeChannelAddress(args, fields[2:]), nil
+	case "direct-address":
+		return p.executeDirectAddress(args, fields[2:]), nil
+	case "status":
+		if !isAdmin {
+			return adminOnly, nil
+		}
+		return p.executeStatus(), nil
+	case "test-connection":
+		if !isAdmin {
+			return adminOnly, nil
+		}
+		return p.executeTestConnection(args), nil
+	case "poll-now":
+		if !isAdmin {
+			return adminOnly, nil
+		}
+		return p.executePollNow(), nil
+	case "audit":
+		if !isAdmin {
+			return adminOnly, nil
+		}
+		return p.executeAudit(fields[2:]), nil
+	default:
+		return p.executeHelp(args), nil
+	}
+}
+
+func (p *Plugin) executeHelp(args *model.CommandArgs) *model.CommandResponse {
+	text := "###### Mailermost Slash Command Help\n" + commandHelp
+	if p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		text += commandAdminHelp
+	}
+
+	return commandResponse(text)
+}
+
+func (p *Plugin) executeMute(args *model.CommandArgs, muted bool) *model.CommandResponse {
+	if err := mailermost.SetMuted(p.API, args.UserId, muted); err != nil {
+		p.API.LogError("Failed to change mute setting", "user_id", args.UserId, "error", err.Error())
+		return commandResponse("Failed to change your setting. Please try again.")
+	}
+
+	if muted {
+		return commandResponse("Your email replies will no longer be posted to Mattermost. Use `/mailermost unmute` to post them again.")
+	}
+
+	return commandResponse("Your email replies will be posted to Mattermost again.")
+}
+
+func (p *Plugin) executeAlias(args *model.CommandArgs, params []string) *model.CommandResponse {
+	usage := commandResponse("Usage: `/mailermost alias add|verify|remove [email or code]` or `/mailermost alias list`.")
+	if len(params) == 0 {
+		return usage
+	}
+
+	if params[0] == "list" {
+		aliases, err := mailermost.Aliases(p.API, args.UserId)
+		if err != nil {
+			p.API.LogError("Failed to get aliases", "user_id", args.UserId, "error", err.Error())
+			return commandResponse("Failed to get your addresses. Please try again.")
+		}
+		if len(aliases) == 0 {
+			return commandResponse("Your email replies are only posted from the email address of your account. Use `/mailermost alias add [email]` to add another address.")
+		}
+		return commandResponse("Your email replies are also posted from:\n* " + strings.Join(aliases, "\n* "))
+	}
+
+	if len(params) != 2 {
+		return usage
+	}
+
+	switch params[0] {
+	case "add":
+		code, err := mailermost.RequestAlias(p.API, args.UserId, params[1])
+		if err != nil {
+			return p.aliasErrorResponse(args.UserId, err)
+		}
+
+		user, appErr := p.API.GetUser(args.UserId)
+		if appErr != nil {
+			p.API.LogError("Failed to get user", "user_id", args.UserId, "error", appErr.Error())
+			return commandResponse("Failed to send the verification code. Please try again.")
+		}
+
+		body := fmt.Sprintf("The Mattermost user @%s asked to post their email replies sent from this address. To confirm that this is your address, run this command in Mattermost within %d hours:<br><br><b>/mailermost alias verify %s</b><br><br>If you did not ask for this, you can ignore this email.", html.EscapeString(user.Username), mailermost.AliasCodeTTL/3600, code)
+		if appErr = p.API.SendMail(params[1], "Verify your email address for Mattermost", body); appErr != nil {
+			p.API.LogError("Failed to send alias verification email", "user_id", args.UserId, "error", appErr.Error())
+			return commandResponse("Failed to send the verification code. Please try again.")
+		}
+
+		return commandResponse(fmt.Sprintf("A verification code was sent to %s. Run `/mailermost alias verify [code]` with it to post email replies from that address.", params[1]))
+	case "verify":
+		address, err := mailermost.VerifyAlias(p.API, args.UserId, params[1])
+		if err != nil {
+			return p.aliasErrorResponse(args.UserId, err)
+		}
+		return commandResponse(fmt.Sprintf("Your email replies sent from %s will now be posted to Mattermost.", address))
+	case "remove":
+		removed, err := mailermost.RemoveAlias(p.API, args.UserId, params[1])
+		if err != nil {
+			return p.aliasErrorResponse(args.UserId, err)
+		}
+		if !removed {
+			return commandResponse(fmt.Sprintf("%s is not one of your addresses.", params[1]))
+		}
+		return commandResponse(fmt.Sprintf("Your email replies sent from %s will no longer be posted to Mattermost.", params[1]))
+	default:
+		return usage
+	}
+}
+
+func (p *Plugin) executeChannelAddress(args *model.CommandArgs, params []string) *model.CommandResponse {
+	channel, appErr := p.API.GetChannel(args.ChannelId)
+	if appErr != nil {
+		p.API.LogError("Failed to get channel", "channel_id", args.ChannelId, "error", appErr.Error())
+		return commandResponse("Failed to get this channel. Please try again.")
+	}
+
+	var permission *model.Permission
+	switch channel.Type {
+	case model.CHANNEL_OPEN:
+		permission = model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES
+	case model.CHANNEL_PRIVATE:
+		permission = model.PERMISSION_MANAGE_PRIVATE_CHANNEL_PROPERTIES
+	default:
+		return commandResponse("Direct and group messages cannot have a channel address.")
+	}
+	if !p.API.HasPermissionToChannel(args.UserId, channel.Id, permission) {
+		return commandResponse("Only channel admins can manage the channel address.")
+	}
+
+	var token string
+	var err error
+	switch {
+	case len(params) == 0:
+		token, err = mailermost.ChannelToken(p.API, channel.Id)
+	case params[0] == "new":
+		token, err = mailermost.NewChannelToken(p.API, channel.Id)
+	case params[0] == "revoke":
+		if err = mailermost.RevokeChannelToken(p.API, channel.Id); err != nil {
+			p.API.LogError("Failed to revoke channel address", "channel_id", channel.Id, "error", err.Error())
+			return commandResponse("Failed to delete the channel address. Please try again.")
+		}
+		return commandResponse("Emails sent to the old channel address will no longer be posted.")
+	default:
+		return commandResponse("Usage: `/mailermost channel-address [new|revoke]`.")
+	}
+	if err != nil {
+		p.API.LogError("Failed to get channel address", "channel_id", channel.Id, "error", err.Error())
+		return commandResponse("Failed to get the channel address. Please try again.")
+	}
+	if token == "" {
+		return commandResponse("This channel has no email address. Use `/mailermost channel-address new` to create one.")
+	}
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		p.API.LogError("Failed to get team", "team_id", channel.TeamId, "error", appErr.Error())
+		return commandResponse("Failed to get the team of this channel. Please try again.")
+	}
+
+	var addresses []string
+	for _, poller := range p.getPollers() {
+		account := poller.Account()
+		if account.AllowsTeam(team.Name) {
+			addresses = append(addresses, mailermost.ChannelAddress(account.Email, token))
+		}
+	}
+	if len(addresses) == 0 {
+		return commandResponse("No mailbox accepts emails for this team.")
+	}
+
+	return commandResponse("Emails sent to this address by members of the channel start new posts in it, with the subject as a heading and the attachments uploaded. Use `/mailermost channel-address new` to replace the address, e.g. if it receives spam.\n* " + strings.Join(addresses, "\n* "))
+}
+
+func (p *Plugin) executeDirectAddress(args *model.CommandArgs, params []string) *model.CommandResponse {
+	teams, appErr := p.API.GetTeamsForUser(args.UserId)
+	if appErr != nil {
+		p.API.LogError("Failed to get teams", "user_id", args.UserId, "error", appErr.Error())
+		return commandResponse("Failed to get your teams. Please try again.")
+	}
+
+	var token string
+	var err error
+	switch {
+	case len(params) == 0:
+		token, err = mailermost.DirectToken(p.API, args.UserId)
+	case params[0] == "new":
+		token, err = mailermost.NewDirectToken(p.API, args.UserId)
+	default:
+		return commandResponse("Usage: `/mailermost direct-address [new]`.")
+	}
+	if err != nil {
+		p.API.LogError("Failed to get direct message address", "user_id", args.UserId, "error", err.Error())
+		return commandResponse("Failed to get your direct message address. Please try again.")
+	}
+
+	// Accounts restricted to teams are listed if they serve one of the teams of the user.
+	var addresses []string
+	for _, poller := range p.getPollers() {
+		account := poller.Account()
+		for _, team := range teams {
+			if account.AllowsTeam(team.Name) {
+				addresses = append(addresses, mailermost.DirectAddress(account.Email, token))
+				break
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return commandResponse("No mailbox accepts direct messages for your teams.")
+	}
+
+	return commandResponse("Emails sent to this address by other users are posted as direct messages to you, with the subject as a heading and the attachments uploaded. Use `/mailermost direct-address new` to replace the address, e.g. if it receives spam.\n* " + strings.Join(addresses, "\n* "))
+}
+
+// aliasErrorResponse explains errors registering alias addresses that the user can act on, and
+// logs the others.
+func (p *Plugin) aliasErrorResponse(userID string, err error) *model.CommandResponse {
+	switch err {
+	case mailermost.ErrInvalidAlias, mailermost.ErrAliasTaken, mailermost.ErrTooManyAliases, mailermost.ErrNoPendingAlias, mailermost.ErrInvalidAliasCode, mailermost.ErrTooManyAliasRequests:
+		return commandResponse("Failed to change your addresses: " + err.Error() + ".")
+	}
+
+	p.API.LogError("Failed to change aliases", "user_id", userID, "error", err.Error())
+	return commandResponse("Failed to change your addresses. Please try again.")
+}
+
+func (p *Plugin) executeHistory(args *model.CommandArgs, params []string, isAdmin bool) *model.CommandResponse {
+	filter := mailermost.AuditFilter{
+		UserID: args.UserId,
+		Limit:  commandHistoryLimit,
+	}
+
+	if len(params) > 0 {
+		if !isAdmin {
+			return commandResponse("Only system admins can view the history of other users.")
+		}
+
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(params[0], "@"))
+		if appErr != nil {
+			return commandResponse(fmt.Sprintf("Unknown user `%s`.", params[0]))
+		}
+		filter.UserID = user.Id
+	}
+
+	entries, err := mailermost.NewAuditLog(p.API).Query(filter)
+	if err != nil {
+		p.API.LogError("Failed to query audit log", "error", err.Error())
+		return commandResponse("Failed to get the history. Please check the server logs.")
+	}
+	if len(entries) == 0 {
+		return commandResponse(fmt.Sprintf("No email replies in the last %d days.", mailermost.AuditRetentionDays))
+	}
+
+	return commandResponse(p.formatAuditEntries(entries))
+}
+
+func (p *Plugin) executeStatus() *model.CommandResponse {
+	pollers := p.getPollers()
+	if len(pollers) == 0 {
+		return commandResponse("Mailermost is not polling any mailbox.")
+	}
+
+	var sb strings.Builder
+	for _, poller := range pollers {
+		status := poller.Status()
+
+		fmt.Fprintf(&sb, "#### %s\n", accountTitle(status.Account, status.Email))
+		if status.LastPoll.IsZero() {
+			sb.WriteString("* Last poll: never on this server\n")
+		} else {
+			fmt.Fprintf(&sb, "* Last poll: %s\n", status.LastPoll.UTC().Format("2006-01-02 15:04:05 UTC"))
+		}
+		if status.Paused {
+			sb.WriteString("* Polling is **paused** after repeated login failures\n")
+		}
+		if status.LastError != "" {
+			fmt.Fprintf(&sb, "* Last error: `%s`\n", status.LastError)
+		}
+		for _, folder := range status.Folders {
+			fmt.Fprintf(&sb, "* Folder `%s`: %d emails", folder.Name, folder.Messages)
+			if folder.LastError != "" {
+				fmt.Fprintf(&sb, ", error: `%s`", folder.LastError)
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return commandResponse(sb.String())
+}
+
+// executeTestConnection tests the connection to each mailbox in the background, as logging in
+// can take as long as the IMAP timeouts, and sends the results to the user from the bot.
+func (p *Plugin) executeTestConnection(args *model.CommandArgs) *model.CommandResponse {
+	pollers := p.getPollers()
+	if len(pollers) == 0 {
+		return commandResponse("Mailermost is not polling any mailbox.")
+	}
+
+	go func() {
+		var sb strings.Builder
+		sb.WriteString("Connection test results:\n")
+		for _, poller := range pollers {
+			account := poller.Account()
+			if err := poller.TestConnection(); err != nil {
+				fmt.Fprintf(&sb, "* %s: :x: `%s`\n", accountTitle(account.Name, account.Email), err.Error())
+				continue
+			}
+			fmt.Fprintf(&sb, "* %s: :white_check_mark: connected\n", accountTitle(account.Name, account.Email))
+		}
+
+		mailermost.NotifyUser(p.API, p.botUserID, args.UserId, sb.String())
+	}()
+
+	return commandResponse(fmt.Sprintf("Testing the connection to each mailbox. @%s will send you the results.", botUsername))
+}
+
+func (p *Plugin) executePollNow() *model.CommandResponse {
+	pollers := p.getPollers()
+	if len(pollers) == 0 {
+		return commandResponse("Mailermost is not polling any mailbox.")
+	}
+
+	for _, poller := range pollers {
+		poller.PollNow()
+	}
+
+	return commandResponse("Checking the mailboxes now. Use `/mailermost status` to see the result.")
+}
+
+// accountTitle names a mailbox account in command responses.
+func accountTitle(name, email string) string {
+	if name == "" {
+		return email
+	}
+
+	return fmt.Sprintf("%s (%s)", name, email)
+}
+
+func (p *Plugin) executeAudit(params []string) *model.CommandResponse {
+	filter := mailermost.AuditFilter{Limit: commandAuditLimit}
+	var days []time.Time
+	for _, param := range params {
+		if day, err := parseDay(param); err == nil {
+			days = append(days, day)
+			continue
+		}
+
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(param, "@"))
+		if appErr != nil {
+			return commandResponse(fmt.Sprintf("Unknown user or invalid date `%s`. Dates are given as YYYY-MM-DD.", param))
+		}
+		filter.UserID = user.Id
+	}
+	if len(days) > 0 {
+		filter.Since = days[0]
+	}
+	if len(days) > 1 {
+		filter.Until = days[1]
+	}
+
+	entries, err := mailermost.NewAuditLog(p.API).Query(filter)
+	if err != nil {
+		p.API.LogError("Failed to query audit log", "error", err.Error())
+		return commandResponse("Failed to query the audit log. Please check the server logs.")
+	}
+	if len(entries) == 0 {
+		return commandResponse("No inbound emails found.")
+	}
+
+	return commandResponse(p.formatAuditEntries(entries))
+}
+
+// formatAuditEntries renders audit entries as a Markdown table.
+func (p *Plugin) formatAuditEntries(entries []mailermost.AuditEntry) string {
+	usernames := make(map[string]string)
+	username := func(userID string) string {
+		if userID == "" {
+			return ""
+		}
+		if name, ok := usernames[userID]; ok {
+			return name
+		}
+
+		name := userID
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			name = "@" + user.Username
+		}
+		usernames[userID] = name
+		return name
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Time (UTC) | Sender | User | Post | Result | Reason |\n")
+	sb.WriteString("|---|---|---|---|---|---|\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s | %s | %s |\n",
+			time.Unix(0, entry.Timestamp*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04:05"),
+			entry.Sender,
+			username(entry.UserID),
+			entry.PostID,
+			entry.Result,
+			entry.Reason,
+		)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+// newCommandTestPlugin returns a plugin polling the given account, with alice as a user and
+// admin as a system admin.
+func newCommandTestPlugin(t *testing.T, api *plugintest.API, accounts ...mailermost.Account) *Plugin {
+	api.On("HasPermissionTo", "alice", model.PERMISSION_MANAGE_SYSTEM).Return(false).Maybe()
+	api.On("HasPermissionTo", "admin", model.PERMISSION_MANAGE_SYSTEM).Return(true).Maybe()
+	api.On("LogError", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	p := &Plugin{botUserID: "bot"}
+	p.SetAPI(api)
+	for _, account := range accounts {
+		poller, err := mailermost.NewPoller(api, "bot", account, mailermost.Settings{PollingInterval: 60}, nil)
+		require.NoError(t, err)
+		p.Pollers = append(p.Pollers, poller)
+	}
+
+	return p
+}
+
+func executeCommand(t *testing.T, p *Plugin, userID, command string) string {
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: userID, ChannelId: "channel", Command: command})
+	require.Nil(t, appErr)
+	assert.Equal(t, model.COMMAND_RESPONSE_TYPE_EPHEMERAL, response.ResponseType)
+
+	return response.Text
+}
+
+func TestExecuteCommandHelp(t *testing.T) {
+	api := &plugintest.API{}
+	p := newCommandTestPlugin(t, api)
+
+	for _, command := range []string{"/mailermost", "/mailermost help", "/mailermost unknown"} {
+		text := executeCommand(t, p, "alice", command)
+		assert.Contains(t, text, "/mailermost mute")
+		assert.NotContains(t, text, "/mailermost status")
+	}
+
+	assert.Contains(t, executeCommand(t, p, "admin", "/mailermost help"), "/mailermost status")
+}
+
+func TestExecuteCommandAdminOnly(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newCommandTestPlugin(t, api)
+
+	for _, subcommand := range []string{"status", "test-connection", "poll-now", "audit"} {
+		assert.Equal(t, "Only system admins can use `/mailermost "+subcommand+"`.", executeCommand(t, p, "alice", "/mailermost "+subcommand))
+	}
+	assert.Equal(t, "Only system admins can view the history of other users.", executeCommand(t, p, "alice", "/mailermost history @bob"))
+}
+
+func TestExecuteCommandMute(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newCommandTestPlugin(t, api)
+
+	api.On("KVSet", "muted_alice", []byte("true")).Return(nil).Once()
+	assert.Contains(t, executeCommand(t, p, "alice", "/mailermost mute"), "will no longer be posted")
+
+	api.On("KVDelete", "muted_alice").Return(nil).Once()
+	assert.Contains(t, executeCommand(t, p, "alice", "/mailermost unmute"), "will be posted to Mattermost again")
+}
+
+func TestExecuteCommandAddresses(t *testing.T) {
+	account := mailermost.Account{Name: "replies", Server: "imap.example.org:993", Security: "ssl", Email: "replies@example.org"}
+
+	t.Run("channel address of a direct message channel", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := newCommandTestPlugin(t, api, account)
+		api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", Type: model.CHANNEL_DIRECT}, nil).Once()
+
+		assert.Equal(t, "Direct and group messages cannot have a channel address.", executeCommand(t, p, "alice", "/mailermost channel-address"))
+	})
+
+	t.Run("channel address without permission", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := newCommandTestPlugin(t, api, account)
+		api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", Type: model.CHANNEL_OPEN}, nil).Once()
+		api.On("HasPermissionToChannel", "alice", "channel", model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES).Return(false).Once()
+
+		assert.Equal(t, "Only channel admins can manage the channel address.", executeCommand(t, p, "alice", "/mailermost channel-address new"))
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+	})
+
+	t.Run("channel address", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := newCommandTestPlugin(t, api, account)
+		api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", TeamId: "team", Type: model.CHANNEL_PRIVATE}, nil).Once()
+		api.On("HasPermissionToChannel", "alice", "channel", model.PERMISSION_MANAGE_PRIVATE_CHANNEL_PROPERTIES).Return(true).Once()
+		api.On("KVGet", "chtoken_channel").Return([]byte("lunchtoken"), nil).Once()
+		api.On("GetTeam", "team").Return(&model.Team{Id: "team", Name: "kitchen"}, nil).Once()
+
+		assert.Contains(t, executeCommand(t, p, "alice", "/mailermost channel-address"), "* replies+ch-lunchtoken@example.org")
+	})
+
+	t.Run("direct address", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := newCommandTestPlugin(t, api, account)
+		api.On("GetTeamsForUser", "alice").Return([]*model.Team{{Id: "team", Name: "kitchen"}}, nil)
+		api.On("KVGet", "dmtoken_alice").Return([]byte("alicetoken"), nil).Once()
+
+		assert.Contains(t, executeCommand(t, p, "alice", "/mailermost direct-address"), "* replies+dm-alicetoken@example.org")
+		assert.Equal(t, "Usage: `/mailermost direct-address [new]`.", executeCommand(t, p, "alice", "/mailermost direct-address revoke"))
+	})
+}
+
+func TestExecuteCommandTestConnection(t *testing.T) {
+	// Nothing listens on the address of the mailbox, so logging in fails.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newCommandTestPlugin(t, api, mailermost.Account{Name: "replies", Server: server, Security: "none", Email: "replies@example.org"})
+
+	results := make(chan string, 1)
+	api.On("GetDirectChannel", "admin", "bot").Return(&model.Channel{Id: "dm"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm" && post.UserId == "bot"
+	})).Run(func(args mock.Arguments) {
+		results <- args.Get(0).(*model.Post).Message
+	}).Return(&model.Post{}, nil).Once()
+
+	assert.Equal(t, "Testing the connection to each mailbox. @mailermost will send you the results.", executeCommand(t, p, "admin", "/mailermost test-connection"))
+
+	select {
+	case message := <-results:
+		assert.True(t, strings.HasPrefix(message, "Connection test results:\n"))
+		assert.Contains(t, message, "* replies (replies@example.org): :x: `failure connecting to IMAP server")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the results of the connection test were not sent")
+	}
+}
+
+func TestExecuteCommandWithoutPollers(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newCommandTestPlugin(t, api)
+
+	for _, subcommand := range []string{"status", "test-connection", "poll-now"} {
+		assert.Equal(t, "Mailermost is not polling any mailbox.", executeCommand(t, p, "admin", "/mailermost "+subcommand))
+	}
+}
+
+func TestExecuteCommandAudit(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newCommandTestPlugin(t, api)
+
+	api.On("GetUserByUsername", "nobody").Return(nil, model.NewAppError("GetUserByUsername", "", nil, "", 404)).Once()
+	assert.Equal(t, "Unknown user or invalid date `@nobody`. Dates are given as YYYY-MM-DD.", executeCommand(t, p, "admin", "/mailermost audit @nobody"))
+
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+	assert.Equal(t, "No inbound emails found.", executeCommand(t, p, "admin", "/mailermost audit 2020-01-01"))
+}
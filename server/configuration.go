@@ -1,9 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"reflect"
+	"strings"
 
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+const (
+	minPollingInterval = 10
+	maxPollingInterval = 24 * 60 * 60
+	maxQuoteLength     = 1000
+	minMaxPostSize     = 100
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -21,8 +35,45 @@ type configuration struct {
 	Server          string
 	Security        string
 	Email           string
+	Username        string
 	Password        string
 	PollingInterval int `json:"polling_interval"`
+	Folders         string
+	// MaxMessageSize is the maximum size of an email in KB. Zero means no limit.
+	MaxMessageSize int `json:"max_message_size"`
+	// EmailRejectionNotices enables emailing users why their reply was not posted, in addition
+	// to the direct message from the bot.
+	EmailRejectionNotices bool `json:"email_rejection_notices"`
+	// AuthorHeader is the header identifying the author of emails. See mailermost.AuthorFrom.
+	AuthorHeader string `json:"author_header"`
+	// StripPlusTags matches senders like user+tag@example.com to the user user@example.com.
+	StripPlusTags bool `json:"strip_plus_tags"`
+	// DomainEquivalences lists domains treated as the same when matching senders to users. See
+	// mailermost.ParseDomainEquivalences.
+	DomainEquivalences string `json:"domain_equivalences"`
+	// QuoteMode is when the post replied to is quoted above replies. See
+	// mailermost.QuoteOutOfOrder.
+	QuoteMode string `json:"quote_mode"`
+	// QuoteLength is the number of characters of the post replied to that are quoted.
+	QuoteLength int `json:"quote_length"`
+	// LongReplies is how replies longer than the server accepts are posted. See
+	// mailermost.LongReplySplit.
+	LongReplies string `json:"long_replies"`
+	// MaxPostSize is the number of characters the server accepts in a post. Zero means the
+	// limit of current database schemas.
+	MaxPostSize int `json:"max_post_size"`
+	// EmailCommands lists the verbs of replies that act on the post replied to instead of being
+	// posted. See mailermost.ParseEmailCommands.
+	EmailCommands string `json:"email_commands"`
+	// NoticeTemplates is a JSON object overriding the templates of the rejection notices, by
+	// locale and message ID.
+	NoticeTemplates string `json:"notice_templates"`
+
+	// Accounts is a JSON list of additional mailbox accounts. See mailermost.Account.
+	Accounts string
+
+	// MetricsToken lets a Prometheus server scrape the metrics endpoint without a session.
+	MetricsToken string `json:"metrics_token"`
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -32,6 +83,147 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// IsValid checks the configuration, including the settings of every mailbox account.
+func (c *configuration) IsValid(replyToAddress string) error {
+	if c.PollingInterval < minPollingInterval || c.PollingInterval > maxPollingInterval {
+		return errors.Errorf("polling interval must be between %d and %d seconds", minPollingInterval, maxPollingInterval)
+	}
+	if c.MaxMessageSize < 0 {
+		return errors.New("maximum email size must not be negative")
+	}
+
+	if err := mailermost.ValidateAuthorHeader(c.AuthorHeader); err != nil {
+		return err
+	}
+	if err := mailermost.ValidateQuoteMode(c.QuoteMode); err != nil {
+		return err
+	}
+	if err := mailermost.ValidateLongReplyMode(c.LongReplies); err != nil {
+		return err
+	}
+	if c.MaxPostSize != 0 && (c.MaxPostSize < minMaxPostSize || c.MaxPostSize > model.POST_MESSAGE_MAX_RUNES_V2) {
+		return errors.Errorf("maximum post size must be 0 or between %d and %d characters", minMaxPostSize, model.POST_MESSAGE_MAX_RUNES_V2)
+	}
+	if c.QuoteLength < 0 || c.QuoteLength > maxQuoteLength {
+		return errors.Errorf("quote length must be between 0 and %d characters", maxQuoteLength)
+	}
+	if _, err := mailermost.ParseDomainEquivalences(c.DomainEquivalences); err != nil {
+		return err
+	}
+	if _, err := mailermost.ParseEmailCommands(c.EmailCommands); err != nil {
+		return err
+	}
+
+	templates, err := c.noticeTemplates()
+	if err != nil {
+		return err
+	}
+	if err = mailermost.ValidateNoticeTemplates(templates); err != nil {
+		return err
+	}
+
+	accounts, err := c.accounts(replyToAddress)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if err = account.IsValid(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// accounts returns the mailbox accounts to poll. The account configured through the single
+// mailbox settings expects replies sent to Email, or to replyToAddress if Email is empty. It is
+// left out if only Accounts is used.
+func (c *configuration) accounts(replyToAddress string) ([]mailermost.Account, error) {
+	var accounts []mailermost.Account
+	if strings.TrimSpace(c.Accounts) != "" {
+		if err := json.Unmarshal([]byte(c.Accounts), &accounts); err != nil {
+			return nil, errors.Wrap(err, "failed to parse accounts")
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, account := range accounts {
+		if account.Name == "" {
+			return nil, errors.New("every account must have a name")
+		}
+		if names[account.Name] {
+			return nil, errors.Errorf("duplicate account name %q", account.Name)
+		}
+		names[account.Name] = true
+	}
+
+	if c.Server != "" || len(accounts) == 0 {
+		email := c.Email
+		if email == "" {
+			email = replyToAddress
+		}
+
+		defaultAccount := mailermost.Account{
+			Server:   c.Server,
+			Security: c.Security,
+			Email:    email,
+			Username: c.Username,
+			Password: c.Password,
+			Folders:  c.Folders,
+		}
+		accounts = append([]mailermost.Account{defaultAccount}, accounts...)
+	}
+
+	return accounts, nil
+}
+
+// noticeTemplates parses the notice template overrides.
+func (c *configuration) noticeTemplates() (map[string]map[string]string, error) {
+	if strings.TrimSpace(c.NoticeTemplates) == "" {
+		return nil, nil
+	}
+
+	var templates map[string]map[string]string
+	if err := json.Unmarshal([]byte(c.NoticeTemplates), &templates); err != nil {
+		return nil, errors.Wrap(err, "failed to parse notice templates")
+	}
+
+	return templates, nil
+}
+
+// settings returns the plugin-wide settings shared by the pollers of all accounts. The
+// configuration must be valid.
+func (c *configuration) settings() mailermost.Settings {
+	templates, _ := c.noticeTemplates()
+	domains, _ := mailermost.ParseDomainEquivalences(c.DomainEquivalences)
+	commands, _ := mailermost.ParseEmailCommands(c.EmailCommands)
+
+	settings := mailermost.Settings{
+		PollingInterval:       c.PollingInterval,
+		EmailRejectionNotices: c.EmailRejectionNotices,
+		NoticeTemplates:       templates,
+		EmailCommands:         commands,
+		QuoteMode:             c.QuoteMode,
+		QuoteLength:           c.QuoteLength,
+		LongReplyMode:         c.LongReplies,
+		MaxPostSize:           c.MaxPostSize,
+		AuthorHeader:          c.AuthorHeader,
+		AddressRules: mailermost.AddressRules{
+			StripPlusTags: c.StripPlusTags,
+			Domains:       domains,
+		},
+	}
+	switch {
+	case c.MaxMessageSize > math.MaxUint32/1024:
+		settings.MaxMessageSize = math.MaxUint32
+	case c.MaxMessageSize > 0:
+		settings.MaxMessageSize = uint32(c.MaxMessageSize) * 1024
+	}
+
+	return settings
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -71,6 +263,19 @@ func (p *Plugin) setConfiguration(configuration *configuration) {
 	}
 
 	p.configuration = configuration
+	p.unfollowVerb = ""
+	if configuration != nil {
+		p.unfollowVerb = unfollowVerb(configuration.settings().EmailCommands)
+	}
+}
+
+// getUnfollowVerb returns a verb of the unfollow email command of the active configuration, or
+// an empty string if there is none.
+func (p *Plugin) getUnfollowVerb() string {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	return p.unfollowVerb
 }
 
 // OnConfigurationChange is invoked when configuration changes may have been made.
@@ -82,7 +287,61 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	if err := configuration.IsValid(*p.API.GetConfig().EmailSettings.ReplyToAddress); err != nil {
+		// The System Console saves the settings regardless of the error, so the admins are
+		// told that the previous settings are still in use. The bot only exists once the
+		// plugin has been activated.
+		if p.botUserID != "" {
+			mailermost.NotifyAdmins(p.API, p.botUserID, fmt.Sprintf("The Mailermost plugin settings were changed but are invalid, the previous settings are still in use:\n`%s`", err.Error()))
+		}
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
+
+	previous := p.getConfiguration()
 	p.setConfiguration(configuration)
 
+	// The server calls this hook once before OnActivate; only swap a poller that is already
+	// running so new IMAP settings apply without restarting the plugin.
+	if p.isPolling() {
+		if err := p.restartPollers(); err != nil {
+			return errors.Wrap(err, "failed to restart pollers")
+		}
+
+		// The hook also runs when unrelated server settings are saved, so the mailboxes are
+		// only tested when the plugin settings changed.
+		if !reflect.DeepEqual(previous, configuration) {
+			go p.testConnections(configuration)
+		}
+	}
+
 	return nil
 }
+
+// testConnections logs into each mailbox of the given configuration and selects its folders.
+// The hook does not tell which admin saved the settings, so every system admin is told the
+// result.
+func (p *Plugin) testConnections(configuration *configuration) {
+	accounts, err := configuration.accounts(*p.API.GetConfig().EmailSettings.ReplyToAddress)
+	if err != nil {
+		p.API.LogError("Failed to test mailbox connections", "error", err.Error())
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("The Mailermost plugin settings were changed. Connection test results:\n")
+	for _, account := range accounts {
+		title := accountTitle(account.Name, account.Email)
+
+		poller, testErr := mailermost.NewPoller(p.API, p.botUserID, account, configuration.settings(), nil)
+		if testErr == nil {
+			testErr = poller.TestConnection()
+		}
+		if testErr != nil {
+			fmt.Fprintf(&sb, "* %s: :x: `%s`\n", title, testErr.Error())
+			continue
+		}
+		fmt.Fprintf(&sb, "* %s: :white_check_mark: connected\n", title)
+	}
+
+	mailermost.NotifyAdmins(p.API, p.botUserID, sb.String())
+}
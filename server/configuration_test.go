@@ -1,9 +1,14 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfiguration(t *testing.T) {
@@ -57,3 +62,136 @@ func TestConfiguration(t *testing.T) {
 		assert.NotEqual(t, configuration1, plugin.getConfiguration())
 	})
 }
+
+func TestConfigurationAccounts(t *testing.T) {
+	const replyTo = "reply@example.org"
+
+	t.Run("single mailbox settings only", func(t *testing.T) {
+		c := &configuration{Server: "imap.example.org:993", Security: "tls", Password: "secret"}
+
+		accounts, err := c.accounts(replyTo)
+		require.NoError(t, err)
+		require.Len(t, accounts, 1)
+		assert.Equal(t, "", accounts[0].Name)
+		assert.Equal(t, replyTo, accounts[0].Email)
+		assert.Equal(t, "imap.example.org:993", accounts[0].Server)
+	})
+
+	t.Run("additional accounts", func(t *testing.T) {
+		c := &configuration{
+			Server:   "imap.example.org:993",
+			Accounts: `[{"name": "sales", "server": "imap.sales.example.org:993", "email": "sales@example.org", "teams": ["sales"]}]`,
+		}
+
+		accounts, err := c.accounts(replyTo)
+		require.NoError(t, err)
+		require.Len(t, accounts, 2)
+		assert.Equal(t, "", accounts[0].Name)
+		assert.Equal(t, "sales", accounts[1].Name)
+		assert.Equal(t, []string{"sales"}, accounts[1].Teams)
+	})
+
+	t.Run("only additional accounts", func(t *testing.T) {
+		c := &configuration{
+			Accounts: `[{"name": "sales", "server": "imap.sales.example.org:993", "email": "sales@example.org"}]`,
+		}
+
+		accounts, err := c.accounts(replyTo)
+		require.NoError(t, err)
+		require.Len(t, accounts, 1)
+		assert.Equal(t, "sales", accounts[0].Name)
+	})
+
+	t.Run("invalid accounts", func(t *testing.T) {
+		for name, list := range map[string]string{
+			"malformed":      `[{"name": }]`,
+			"missing name":   `[{"server": "imap.example.org:993"}]`,
+			"duplicate name": `[{"name": "a"}, {"name": "a"}]`,
+		} {
+			c := &configuration{Accounts: list}
+			_, err := c.accounts(replyTo)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestConfigurationIsValid(t *testing.T) {
+	const replyTo = "reply@example.org"
+
+	valid := configuration{
+		Server:          "imap.example.org:993",
+		Security:        "ssl",
+		PollingInterval: 60,
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		c := valid
+		assert.NoError(t, c.IsValid(replyTo))
+	})
+
+	for name, change := range map[string]func(c *configuration){
+		"polling interval too short":  func(c *configuration) { c.PollingInterval = 1 },
+		"polling interval too long":   func(c *configuration) { c.PollingInterval = 2 * maxPollingInterval },
+		"missing port":                func(c *configuration) { c.Server = "imap.example.org" },
+		"invalid port":                func(c *configuration) { c.Server = "imap.example.org:imap" },
+		"unencrypted on the TLS port": func(c *configuration) { c.Security = "none" },
+		"encrypted on the plain port": func(c *configuration) { c.Server = "imap.example.org:143" },
+		"unknown security":            func(c *configuration) { c.Security = "starttls" },
+		"invalid additional account": func(c *configuration) {
+			c.Accounts = `[{"name": "sales", "server": "imap.example.org", "email": "sales@example.org"}]`
+		},
+		"negative maximum email size":     func(c *configuration) { c.MaxMessageSize = -1 },
+		"additional account without name": func(c *configuration) { c.Accounts = `[{"server": "imap.example.org:993"}]` },
+		"unknown author header":           func(c *configuration) { c.AuthorHeader = "to" },
+		"unknown quote mode":              func(c *configuration) { c.QuoteMode = "sometimes" },
+		"negative quote length":           func(c *configuration) { c.QuoteLength = -1 },
+		"unknown long reply mode":         func(c *configuration) { c.LongReplies = "truncate" },
+		"tiny maximum post size":          func(c *configuration) { c.MaxPostSize = 10 },
+		"malformed notice templates":      func(c *configuration) { c.NoticeTemplates = `{"en": "text"}` },
+		"invalid notice template":         func(c *configuration) { c.NoticeTemplates = `{"en": {"intro": "{{.Subject"}}` },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			change(&c)
+			assert.Error(t, c.IsValid(replyTo))
+		})
+	}
+}
+
+func TestOnConfigurationChangeInvalid(t *testing.T) {
+	newPlugin := func(api *plugintest.API) *Plugin {
+		api.On("LoadPluginConfiguration", mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(0).(*configuration).PollingInterval = 1
+		}).Return(nil)
+		api.On("GetConfig").Return(&model.Config{EmailSettings: model.EmailSettings{ReplyToAddress: model.NewString("reply@example.org")}})
+
+		p := &Plugin{}
+		p.SetAPI(api)
+		return p
+	}
+
+	t.Run("admins are told the error", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := newPlugin(api)
+		p.botUserID = "bot"
+
+		api.On("GetUsers", mock.MatchedBy(func(options *model.UserGetOptions) bool {
+			return options.Role == model.SYSTEM_ADMIN_ROLE_ID
+		})).Return([]*model.User{{Id: "admin"}}, nil).Once()
+		api.On("GetDirectChannel", "admin", "bot").Return(&model.Channel{Id: "dm"}, nil).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.ChannelId == "dm" && strings.Contains(post.Message, "polling interval must be between")
+		})).Return(&model.Post{}, nil).Once()
+
+		assert.Error(t, p.OnConfigurationChange())
+	})
+
+	t.Run("not yet activated", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := newPlugin(api)
+
+		assert.Error(t, p.OnConfigurationChange())
+	})
+}
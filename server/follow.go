@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+// MessageHasBeenPosted emails the users following the thread of a reply by email, so that they
+// can reply to the email.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.RootId == "" || post.IsSystemMessage() {
+		return
+	}
+
+	// Replies in threads nobody follows are the norm, and do not need a KV store lookup.
+	followed, err := p.followedThreads.Followed(post.RootId)
+	if err != nil {
+		p.API.LogError("Failed to get followed threads", "root_id", post.RootId, "error", err.Error())
+		return
+	}
+	if !followed {
+		return
+	}
+
+	followers, err := mailermost.ThreadFollowers(p.API, post.RootId)
+	if err != nil {
+		p.API.LogError("Failed to get thread followers", "root_id", post.RootId, "error", err.Error())
+		return
+	}
+	if len(followers) == 0 {
+		return
+	}
+
+	author, appErr := p.API.GetUser(post.UserId)
+	if appErr != nil {
+		p.API.LogError("Failed to get author of reply", "post_id", post.Id, "error", appErr.Error())
+		return
+	}
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		p.API.LogError("Failed to get channel of reply", "post_id", post.Id, "error", appErr.Error())
+		return
+	}
+
+	verb := p.getUnfollowVerb()
+	for _, followerID := range followers {
+		if followerID == post.UserId {
+			continue
+		}
+		if err = p.emailFollower(followerID, post, author, channel, verb); err != nil {
+			p.API.LogError("Failed to email thread follower", "user_id", followerID, "post_id", post.Id, "error", err.Error())
+		}
+	}
+}
+
+// unfollowVerb returns a verb of the unfollow email command among the given commands, or an
+// empty string if there is none.
+func unfollowVerb(commands map[string]mailermost.EmailCommand) string {
+	var verbs []string
+	for verb, command := range commands {
+		if command.Action == mailermost.ActionUnfollow {
+			verbs = append(verbs, verb)
+		}
+	}
+	if len(verbs) == 0 {
+		return ""
+	}
+
+	// Several verbs may unfollow, the same one is shown in every email.
+	sort.Strings(verbs)
+	return verbs[0]
+}
+
+// emailFollower emails a reply to a user following its thread, if they can still read it. The
+// email links the reply, so that answering it posts in the thread, and names the unfollow verb
+// if there is one.
+func (p *Plugin) emailFollower(followerID string, post *model.Post, author *model.User, channel *model.Channel, unfollowVerb string) error {
+	follower, appErr := p.API.GetUser(followerID)
+	if appErr != nil {
+		return appErr
+	}
+	if follower.DeleteAt != 0 {
+		return nil
+	}
+	if _, appErr = p.API.GetChannelMember(channel.Id, follower.Id); appErr != nil {
+		return nil
+	}
+
+	// Permalinks of direct and group messages work with any team of the user.
+	teamID := channel.TeamId
+	if teamID == "" {
+		teams, teamsErr := p.API.GetTeamsForUser(follower.Id)
+		if teamsErr != nil {
+			return teamsErr
+		}
+		if len(teams) == 0 {
+			return nil
+		}
+		teamID = teams[0].Id
+	}
+	team, appErr := p.API.GetTeam(teamID)
+	if appErr != nil {
+		return appErr
+	}
+
+	config := p.API.GetConfig()
+	siteURL := strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	permalink := siteURL + "/" + team.Name + "/pl/" + post.Id
+
+	channelName := channel.DisplayName
+	if channel.Type == model.CHANNEL_DIRECT || channel.Type == model.CHANNEL_GROUP {
+		channelName = "a direct message"
+	}
+
+	subject := fmt.Sprintf("[%s] New reply in a thread you follow", *config.TeamSettings.SiteName)
+	body := fmt.Sprintf("@%s replied in %s:<br><br>%s<br><br><a href=\"%s\">Open in Mattermost</a><br><br>Reply to this email to post in the thread.",
+		html.EscapeString(author.Username),
+		html.EscapeString(channelName),
+		strings.Replace(html.EscapeString(post.Message), "\n", "<br>", -1),
+		permalink,
+	)
+	if unfollowVerb != "" {
+		body += fmt.Sprintf(" Reply <b>%s</b> to stop these emails.", html.EscapeString(unfollowVerb))
+	}
+
+	if appErr = p.API.SendMail(follower.Email, subject, body); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
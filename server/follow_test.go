@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+func TestUnfollowVerb(t *testing.T) {
+	p := &Plugin{}
+	assert.Equal(t, "", p.getUnfollowVerb())
+
+	p.setConfiguration(&configuration{EmailCommands: "/follow follow\n/stop unfollow\n/unfollow unfollow"})
+	assert.Equal(t, "/stop", p.getUnfollowVerb())
+
+	p.setConfiguration(&configuration{EmailCommands: "/follow follow"})
+	assert.Equal(t, "", p.getUnfollowVerb())
+}
+
+func TestMessageHasBeenPosted(t *testing.T) {
+	rootID := model.NewId()
+
+	t.Run("threads nobody follows", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := &Plugin{followedThreads: mailermost.NewFollowedThreads(api)}
+		p.SetAPI(api)
+
+		api.On("KVGet", "followed_threads").Return([]byte(`["`+model.NewId()+`"]`), nil).Once()
+		for i := 0; i < 3; i++ {
+			p.MessageHasBeenPosted(nil, &model.Post{Id: model.NewId(), RootId: rootID, UserId: "bob", Message: "Noon?"})
+		}
+		p.MessageHasBeenPosted(nil, &model.Post{Id: model.NewId(), UserId: "bob", Message: "Lunch?"})
+	})
+
+	t.Run("followed thread", func(t *testing.T) {
+		config := &model.Config{}
+		config.SetDefaults()
+		config.ServiceSettings.SiteURL = model.NewString("https://chat.example.org")
+
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := &Plugin{followedThreads: mailermost.NewFollowedThreads(api)}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{EmailCommands: "/unfollow unfollow"})
+
+		post := &model.Post{Id: model.NewId(), RootId: rootID, ChannelId: "channel", UserId: "bob", Message: "Noon?"}
+		api.On("KVGet", "followed_threads").Return([]byte(`["`+rootID+`"]`), nil).Once()
+		api.On("KVGet", "follow_"+rootID).Return([]byte(`["alice","bob"]`), nil).Once()
+		api.On("GetUser", "bob").Return(&model.User{Id: "bob", Username: "bob"}, nil).Once()
+		api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", TeamId: "team", DisplayName: "Lunch"}, nil).Once()
+		api.On("GetUser", "alice").Return(&model.User{Id: "alice", Email: "alice@example.org"}, nil).Once()
+		api.On("GetChannelMember", "channel", "alice").Return(&model.ChannelMember{}, nil).Once()
+		api.On("GetTeam", "team").Return(&model.Team{Id: "team", Name: "kitchen"}, nil).Once()
+		api.On("GetConfig").Return(config).Once()
+		api.On("SendMail", "alice@example.org", mock.Anything, mock.MatchedBy(func(body string) bool {
+			return strings.Contains(body, "https://chat.example.org/kitchen/pl/"+post.Id) &&
+				strings.Contains(body, "Reply <b>/unfollow</b> to stop these emails.")
+		})).Return(nil).Once()
+
+		p.MessageHasBeenPosted(nil, post)
+	})
+}
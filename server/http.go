@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-email-reply/server/mailermost"
+)
+
+const (
+	dayLayout         = "2006-01-02"
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+
+	metricsTokenHeader = "X-Metrics-Token"
+	metricsTokenParam  = "token"
+)
+
+// ServeHTTP handles HTTP requests to the plugin.
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	// Prometheus scrapes the metrics without a session, so they have their own authorization.
+	if r.URL.Path == "/metrics" {
+		p.handleMetrics(w, r)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v1/audit":
+		p.handleAudit(w, r, userID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleAudit returns the audit log entries selected by the user_id, since, until and limit
+// query parameters, newest first. Dates are given as YYYY-MM-DD in UTC.
+func (p *Plugin) handleAudit(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := mailermost.AuditFilter{
+		UserID: query.Get("user_id"),
+		Limit:  defaultAuditLimit,
+	}
+
+	var err error
+	if filter.Since, err = parseDay(query.Get("since")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseDay(query.Get("until")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxAuditLimit {
+			http.Error(w, "limit must be a number between 1 and "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := mailermost.NewAuditLog(p.API).Query(filter)
+	if err != nil {
+		p.API.LogError("Failed to query audit log", "error", err.Error())
+		http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []mailermost.AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(entries); err != nil {
+		p.API.LogError("Failed to write audit log response", "error", err.Error())
+	}
+}
+
+// handleMetrics writes the poller metrics in the Prometheus text exposition format. It is
+// readable by system admins and by clients sending the configured metrics token.
+func (p *Plugin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.canReadMetrics(r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := p.metrics.WriteTo(w); err != nil {
+		p.API.LogError("Failed to write metrics response", "error", err.Error())
+	}
+}
+
+// canReadMetrics reports whether the request carries the metrics token, or comes from a system
+// admin. The server removes the Authorization header and the access_token query parameter from
+// plugin requests, so the token is sent in its own header or query parameter.
+func (p *Plugin) canReadMetrics(r *http.Request) bool {
+	token := p.getConfiguration().MetricsToken
+	if token != "" {
+		given := r.Header.Get(metricsTokenHeader)
+		if given == "" {
+			given = r.URL.Query().Get(metricsTokenParam)
+		}
+		if given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	return userID != "" && p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM)
+}
+
+// parseDay parses a YYYY-MM-DD date in UTC. An empty string yields the zero time.
+func parseDay(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	day, err := time.Parse(dayLayout, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+	}
+
+	return day, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanReadMetrics(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("HasPermissionTo", "admin", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{MetricsToken: "secret"})
+
+	for name, test := range map[string]struct {
+		url      string
+		header   string
+		value    string
+		expected bool
+	}{
+		"token parameter":     {url: "/metrics?token=secret", expected: true},
+		"token header":        {url: "/metrics", header: metricsTokenHeader, value: "secret", expected: true},
+		"wrong token":         {url: "/metrics?token=guess"},
+		"no token":            {url: "/metrics"},
+		"system admin":        {url: "/metrics", header: "Mattermost-User-Id", value: "admin", expected: true},
+		"user":                {url: "/metrics", header: "Mattermost-User-Id", value: "user"},
+		"bearer token header": {url: "/metrics", header: "Authorization", value: "Bearer secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.url, nil)
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			assert.Equal(t, test.expected, p.canReadMetrics(r))
+		})
+	}
+}
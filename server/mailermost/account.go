@@ -0,0 +1,120 @@
+package mailermost
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	accountNameRe string = `^[a-z0-9_-]{1,30}$`
+
+	securityNone string = "none"
+	securitySSL  string = "ssl"
+	securityTLS  string = "tls"
+)
+
+// Account holds the settings of a mailbox that replies are read from.
+type Account struct {
+	// Name identifies the account in logs and in plugin KV store keys. The account configured
+	// through the single mailbox settings has an empty name.
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	Security string `json:"security"`
+	// Email is the address replies are sent to. Emails not addressed to it are left alone.
+	Email string `json:"email"`
+	// Username is the IMAP login name. It defaults to Email, but differs e.g. for shared
+	// mailboxes accessed with a delegated login.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Folders is a comma-separated list of folders to check. See ParseFolders.
+	Folders string `json:"folders"`
+	// Teams restricts the account to replies to posts in the teams with these names. Replies
+	// to posts in any team are accepted if it is empty. Direct and group messages are accepted
+	// from members of the teams.
+	Teams []string `json:"teams"`
+}
+
+// IsValid reports the first problem found in the account settings.
+func (a *Account) IsValid() error {
+	if a.Name != "" && !regexp.MustCompile(accountNameRe).MatchString(a.Name) {
+		return errors.Errorf("invalid account name %q, only up to 30 lowercase letters, digits, '-' and '_' are allowed", a.Name)
+	}
+	if a.Server == "" {
+		return errors.Errorf("account %q has no server", a.Name)
+	}
+	if err := validateServer(a.Server, a.Security); err != nil {
+		return errors.Wrapf(err, "account %q", a.Name)
+	}
+	if a.Email == "" {
+		return errors.Errorf("account %q has no email address", a.Name)
+	}
+
+	return nil
+}
+
+// validateServer checks that server is a host and port, and that the port fits the security
+// setting. Ports 143 and 993 are the well-known ports for unencrypted and TLS IMAP.
+func validateServer(server, security string) error {
+	host, portText, err := net.SplitHostPort(server)
+	if err != nil || host == "" {
+		return errors.Errorf("invalid server address %q, expected host:port, e.g. imap.example.com:993", server)
+	}
+
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return errors.Errorf("invalid port %q in server address %q", portText, server)
+	}
+
+	switch security {
+	case securityNone:
+		if port == 993 {
+			return errors.New("port 993 expects an encrypted connection, but security is set to none")
+		}
+	case "", securitySSL, securityTLS:
+		if port == 143 {
+			return errors.Errorf("port 143 expects an unencrypted connection, but security is set to %s", security)
+		}
+	default:
+		return errors.Errorf("unknown security %q, expected none, ssl or tls", security)
+	}
+
+	return nil
+}
+
+// AllowsTeam reports whether replies to posts in the team with the given name are accepted
+// from this account.
+func (a *Account) AllowsTeam(teamName string) bool {
+	if len(a.Teams) == 0 {
+		return true
+	}
+
+	for _, team := range a.Teams {
+		if strings.EqualFold(team, teamName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// username returns the IMAP login name of the account.
+func (a *Account) username() string {
+	if a.Username != "" {
+		return a.Username
+	}
+
+	return a.Email
+}
+
+// keySuffix returns the suffix that makes KV store keys unique per account.
+func (a *Account) keySuffix() string {
+	if a.Name == "" {
+		return ""
+	}
+
+	return "_" + a.Name
+}
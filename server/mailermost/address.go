@@ -0,0 +1,80 @@
+package mailermost
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AddressRules normalize sender addresses that match no user, so that e.g.
+// first.last+mattermost@mail.example.com is matched to the user first.last@example.com.
+type AddressRules struct {
+	// StripPlusTags removes +tags from the local part of addresses.
+	StripPlusTags bool
+	// Domains maps domains, or wildcards like *.example.com for all subdomains, to the domain
+	// they are equivalent to.
+	Domains map[string]string
+}
+
+// ParseDomainEquivalences parses lines like "example.com: mail.example.com, *.example.org" into
+// a map of each listed domain to the domain before the colon.
+func ParseDomainEquivalences(text string) (map[string]string, error) {
+	domains := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		canonical := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || canonical == "" || strings.HasPrefix(canonical, "*") {
+			return nil, errors.Errorf("invalid domain equivalence %q, expected a domain, a colon and a list of equivalent domains", line)
+		}
+
+		for _, domain := range strings.Split(parts[1], ",") {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if domain == "" {
+				continue
+			}
+			if strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+				return nil, errors.Errorf("invalid domain %q, only a leading *. is allowed", domain)
+			}
+			domains[domain] = canonical
+		}
+	}
+
+	return domains, nil
+}
+
+// normalize applies the rules to an address. The result is lower case.
+func (r AddressRules) normalize(address string) string {
+	address = strings.ToLower(address)
+
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address
+	}
+	local, domain := address[:at], address[at+1:]
+
+	if r.StripPlusTags {
+		if plus := strings.Index(local, "+"); plus > 0 {
+			local = local[:plus]
+		}
+	}
+
+	if canonical, ok := r.Domains[domain]; ok {
+		domain = canonical
+	} else {
+		// The closest wildcard wins, e.g. *.eu.example.com over *.example.com.
+		for parent := domain; strings.Contains(parent, "."); {
+			parent = parent[strings.Index(parent, ".")+1:]
+			if wildcardDomain, ok := r.Domains["*."+parent]; ok {
+				domain = wildcardDomain
+				break
+			}
+		}
+	}
+
+	return local + "@" + domain
+}
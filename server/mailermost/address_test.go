@@ -0,0 +1,35 @@
+package mailermost
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressRules(t *testing.T) {
+	domains, err := ParseDomainEquivalences("example.com: mail.example.com, *.example.com\n\nexample.org: *.eu.example.com\n")
+	require.NoError(t, err)
+
+	rules := AddressRules{StripPlusTags: true, Domains: domains}
+	for address, expected := range map[string]string{
+		"First.Last@Example.com":          "first.last@example.com",
+		"first.last+work@example.com":     "first.last@example.com",
+		"+work@example.com":               "+work@example.com",
+		"first.last@mail.example.com":     "first.last@example.com",
+		"first.last@dev.corp.example.com": "first.last@example.com",
+		"first.last@paris.eu.example.com": "first.last@example.org",
+		"first.last@example.net":          "first.last@example.net",
+		"first.last+a+b@mail.example.com": "first.last@example.com",
+		"not an address":                  "not an address",
+	} {
+		assert.Equal(t, expected, rules.normalize(address), address)
+	}
+
+	assert.Equal(t, "first.last+work@example.com", AddressRules{}.normalize("first.last+work@example.com"))
+
+	for _, invalid := range []string{"example.com", "*.example.com: example.org", "example.com: ex*ample.org"} {
+		_, err = ParseDomainEquivalences(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
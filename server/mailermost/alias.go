@@ -0,0 +1,355 @@
+package mailermost
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	aliasKeyPrefix         string = "alias_"
+	aliasListKeyPrefix     string = "aliases_"
+	pendingAliasKeyPrefix  string = "alias_pending_"
+	aliasRequestsKeyPrefix string = "alias_requests_"
+
+	// MaxAliases is the number of alias addresses a user can register.
+	MaxAliases = 10
+	// AliasCodeTTL is how long a verification code is valid, in seconds.
+	AliasCodeTTL int64 = 24 * 60 * 60
+	// MaxAliasRequests is the number of verification codes a user can request per
+	// AliasRequestPeriod, so that the command cannot be used to flood an address with emails.
+	MaxAliasRequests = 5
+	// AliasRequestPeriod is the period MaxAliasRequests applies to, in seconds.
+	AliasRequestPeriod int64 = 24 * 60 * 60
+
+	aliasCodeLength          = 10
+	maxAliasCodeAttempts     = 5
+	maxAliasRequestsAttempts = 5
+)
+
+// Errors returned when registering alias addresses, suitable to show to users.
+var (
+	ErrInvalidAlias         = errors.New("not a valid email address")
+	ErrAliasTaken           = errors.New("the address already belongs to a Mattermost user")
+	ErrTooManyAliases       = errors.Errorf("you cannot register more than %d addresses", MaxAliases)
+	ErrNoPendingAlias       = errors.New("no address is waiting for verification, or the code expired")
+	ErrInvalidAliasCode     = errors.New("the verification code is not correct")
+	ErrTooManyAliasRequests = errors.Errorf("you cannot request more than %d verification codes in %d hours", MaxAliasRequests, AliasRequestPeriod/3600)
+)
+
+// aliasRequests counts the verification codes a user requested in the current period.
+type aliasRequests struct {
+	// Since is the start of the period, in milliseconds since the epoch.
+	Since int64
+	Count int
+}
+
+// pendingAlias is an alias address waiting for its owner to enter the code emailed to it.
+type pendingAlias struct {
+	Address  string
+	Code     string
+	Attempts int
+}
+
+// aliasKey returns the KV store key mapping an alias address to its user. The address is hashed
+// to stay within the KV store key length limit.
+func aliasKey(address string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(address)))
+	return aliasKeyPrefix + hex.EncodeToString(sum[:])[:32]
+}
+
+// RequestAlias starts registering address as an alias of the given user, and returns the code
+// that must be sent to the address to verify it. A code requested earlier becomes invalid, and
+// only MaxAliasRequests codes can be requested per AliasRequestPeriod.
+func RequestAlias(api plugin.API, userID, address string) (string, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if !model.IsValidEmail(address) {
+		return "", ErrInvalidAlias
+	}
+	if _, appErr := api.GetUserByEmail(address); appErr == nil {
+		return "", ErrAliasTaken
+	}
+
+	owner, err := aliasOwner(api, address)
+	if err != nil {
+		return "", err
+	}
+	if owner != "" && owner != userID {
+		return "", ErrAliasTaken
+	}
+
+	aliases, err := Aliases(api, userID)
+	if err != nil {
+		return "", err
+	}
+	if len(aliases) >= MaxAliases {
+		return "", ErrTooManyAliases
+	}
+
+	if err = countAliasRequest(api, userID); err != nil {
+		return "", err
+	}
+
+	pending := pendingAlias{Address: address, Code: model.NewRandomString(aliasCodeLength)}
+	if err = setPendingAlias(api, userID, pending); err != nil {
+		return "", err
+	}
+
+	return pending.Code, nil
+}
+
+// VerifyAlias registers the alias address waiting for verification by the given user if code
+// matches the one sent to it, and returns the address.
+func VerifyAlias(api plugin.API, userID, code string) (string, error) {
+	key := pendingAliasKeyPrefix + userID
+	value, appErr := api.KVGet(key)
+	if appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to get pending alias of user %s", userID)
+	}
+	if value == nil {
+		return "", ErrNoPendingAlias
+	}
+
+	var pending pendingAlias
+	if err := json.Unmarshal(value, &pending); err != nil {
+		return "", errors.Wrapf(err, "failed to parse pending alias of user %s", userID)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(strings.TrimSpace(code))), []byte(pending.Code)) != 1 {
+		// Too many wrong guesses drop the pending alias, so the code cannot be guessed.
+		pending.Attempts++
+		if pending.Attempts >= maxAliasCodeAttempts {
+			if appErr = api.KVDelete(key); appErr != nil {
+				return "", errors.Wrapf(appErr, "failed to delete pending alias of user %s", userID)
+			}
+			return "", ErrNoPendingAlias
+		}
+		if err := setPendingAlias(api, userID, pending); err != nil {
+			return "", err
+		}
+		return "", ErrInvalidAliasCode
+	}
+
+	stored, appErr := api.KVSetWithOptions(aliasKey(pending.Address), []byte(userID), model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+	if appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to register alias of user %s", userID)
+	}
+	if !stored {
+		owner, err := aliasOwner(api, pending.Address)
+		if err != nil {
+			return "", err
+		}
+		if owner != userID {
+			return "", ErrAliasTaken
+		}
+	}
+
+	if err := updateAliases(api, userID, func(aliases []string) []string {
+		for _, alias := range aliases {
+			if alias == pending.Address {
+				return aliases
+			}
+		}
+		return append(aliases, pending.Address)
+	}); err != nil {
+		return "", err
+	}
+
+	if appErr = api.KVDelete(key); appErr != nil {
+		api.LogError("Failed to delete pending alias", "user_id", userID, "error", appErr.Error())
+	}
+
+	return pending.Address, nil
+}
+
+// RemoveAlias unregisters an alias address of the given user. It reports whether the address
+// was an alias of the user.
+func RemoveAlias(api plugin.API, userID, address string) (bool, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+
+	owner, err := aliasOwner(api, address)
+	if err != nil {
+		return false, err
+	}
+	if owner != userID {
+		return false, nil
+	}
+
+	if appErr := api.KVDelete(aliasKey(address)); appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to remove alias of user %s", userID)
+	}
+
+	err = updateAliases(api, userID, func(aliases []string) []string {
+		kept := aliases[:0]
+		for _, alias := range aliases {
+			if alias != address {
+				kept = append(kept, alias)
+			}
+		}
+		return kept
+	})
+
+	return true, err
+}
+
+// Aliases returns the verified alias addresses of the given user.
+func Aliases(api plugin.API, userID string) ([]string, error) {
+	value, appErr := api.KVGet(aliasListKeyPrefix + userID)
+	if appErr != nil {
+		return nil, errors.Wrapf(appErr, "failed to get aliases of user %s", userID)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	var aliases []string
+	if err := json.Unmarshal(value, &aliases); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse aliases of user %s", userID)
+	}
+
+	return aliases, nil
+}
+
+// lookupUser returns the user with the given email address. If there is none, the verified
+// aliases, and then the address normalized by the address rules, are looked up. It returns nil
+// if no user matches.
+func (p *Poller) lookupUser(address string) (*model.User, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	// Users may have been created with either form of an internationalized domain.
+	candidates := []string{address}
+	if ascii := asciiAddress(address); ascii != "" {
+		candidates = append(candidates, ascii)
+	}
+	if normalized := p.settings.AddressRules.normalize(address); normalized != strings.ToLower(address) {
+		candidates = append(candidates, normalized)
+	}
+
+	for _, candidate := range candidates {
+		if user, appErr := p.api.GetUserByEmail(candidate); appErr == nil {
+			return user, nil
+		}
+
+		userID, err := aliasOwner(p.api, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if userID == "" {
+			continue
+		}
+
+		user, appErr := p.api.GetUser(userID)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to get user %s of alias", userID)
+		}
+		return user, nil
+	}
+
+	return nil, nil
+}
+
+// aliasOwner returns the ID of the user the alias address belongs to, or an empty string.
+func aliasOwner(api plugin.API, address string) (string, error) {
+	value, appErr := api.KVGet(aliasKey(address))
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get alias")
+	}
+
+	return string(value), nil
+}
+
+// countAliasRequest counts a verification code requested by the given user, or returns
+// ErrTooManyAliasRequests if the user already requested MaxAliasRequests codes in the current period.
+// Users may run the command concurrently, so the count is updated with a compare-and-set.
+func countAliasRequest(api plugin.API, userID string) error {
+	key := aliasRequestsKeyPrefix + userID
+	for attempt := 0; attempt < maxAliasRequestsAttempts; attempt++ {
+		current, appErr := api.KVGet(key)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to get alias requests of user %s", userID)
+		}
+
+		now := model.GetMillis()
+		period := AliasRequestPeriod * int64(time.Second/time.Millisecond)
+		requests := aliasRequests{Since: now}
+		if current != nil {
+			var stored aliasRequests
+			if err := json.Unmarshal(current, &stored); err != nil {
+				return errors.Wrapf(err, "failed to parse alias requests of user %s", userID)
+			}
+			if now-stored.Since < period {
+				requests = stored
+			}
+		}
+		if requests.Count >= MaxAliasRequests {
+			return ErrTooManyAliasRequests
+		}
+		requests.Count++
+
+		value, err := json.Marshal(requests)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal alias requests")
+		}
+
+		// The count expires at the end of its period.
+		expiry := (requests.Since + period - now) / int64(time.Second/time.Millisecond)
+		if expiry < 1 {
+			expiry = 1
+		}
+		saved, appErr := api.KVSetWithOptions(key, value, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        current,
+			ExpireInSeconds: expiry,
+		})
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to set alias requests of user %s", userID)
+		}
+		if saved {
+			return nil
+		}
+	}
+
+	return errors.Errorf("failed to count alias request of user %s after %d attempts", userID, maxAliasRequestsAttempts)
+}
+
+func setPendingAlias(api plugin.API, userID string, pending pendingAlias) error {
+	value, err := json.Marshal(pending)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal pending alias")
+	}
+
+	if appErr := api.KVSetWithExpiry(pendingAliasKeyPrefix+userID, value, AliasCodeTTL); appErr != nil {
+		return errors.Wrapf(appErr, "failed to set pending alias of user %s", userID)
+	}
+
+	return nil
+}
+
+// updateAliases replaces the alias list of the given user with the result of update. Only the
+// user changes their own list, so it is not updated atomically.
+func updateAliases(api plugin.API, userID string, update func([]string) []string) error {
+	aliases, err := Aliases(api, userID)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(update(aliases))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal aliases")
+	}
+
+	if appErr := api.KVSet(aliasListKeyPrefix+userID, value); appErr != nil {
+		return errors.Wrapf(appErr, "failed to set aliases of user %s", userID)
+	}
+
+	return nil
+}
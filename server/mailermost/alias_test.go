@@ -0,0 +1,155 @@
+package mailermost
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestAlias(t *testing.T) {
+	mockChecks := func(api *plugintest.API) {
+		api.On("GetUserByEmail", "flast@example.org").Return(nil, &model.AppError{})
+		api.On("KVGet", aliasKey("flast@example.org")).Return(nil, nil)
+		api.On("KVGet", aliasListKeyPrefix+"user").Return(nil, nil)
+	}
+	requests := func(since int64, count int) []byte {
+		value, err := json.Marshal(aliasRequests{Since: since, Count: count})
+		require.NoError(t, err)
+		return value
+	}
+	countIs := func(count int) interface{} {
+		return mock.MatchedBy(func(value []byte) bool {
+			var r aliasRequests
+			return json.Unmarshal(value, &r) == nil && r.Count == count
+		})
+	}
+
+	t.Run("first request starts a period", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		mockChecks(api)
+		api.On("KVGet", aliasRequestsKeyPrefix+"user").Return(nil, nil)
+		api.On("KVSetWithOptions", aliasRequestsKeyPrefix+"user", countIs(1), mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+			return options.Atomic && options.OldValue == nil && options.ExpireInSeconds == AliasRequestPeriod
+		})).Return(true, nil)
+		api.On("KVSetWithExpiry", pendingAliasKeyPrefix+"user", mock.Anything, AliasCodeTTL).Return(nil)
+
+		code, err := RequestAlias(api, "user", " FLast@example.org ")
+		require.NoError(t, err)
+		assert.Len(t, code, aliasCodeLength)
+	})
+
+	t.Run("request within the period is counted", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		current := requests(model.GetMillis()-60*1000, 2)
+		mockChecks(api)
+		api.On("KVGet", aliasRequestsKeyPrefix+"user").Return(current, nil)
+		api.On("KVSetWithOptions", aliasRequestsKeyPrefix+"user", countIs(3), mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+			return options.Atomic && string(options.OldValue) == string(current) && options.ExpireInSeconds < AliasRequestPeriod
+		})).Return(true, nil)
+		api.On("KVSetWithExpiry", pendingAliasKeyPrefix+"user", mock.Anything, AliasCodeTTL).Return(nil)
+
+		_, err := RequestAlias(api, "user", "flast@example.org")
+		require.NoError(t, err)
+	})
+
+	t.Run("too many requests send no code", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		mockChecks(api)
+		api.On("KVGet", aliasRequestsKeyPrefix+"user").Return(requests(model.GetMillis(), MaxAliasRequests), nil)
+
+		_, err := RequestAlias(api, "user", "flast@example.org")
+		assert.Equal(t, ErrTooManyAliasRequests, err)
+	})
+
+	t.Run("expired period starts over", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		mockChecks(api)
+		api.On("KVGet", aliasRequestsKeyPrefix+"user").Return(requests(model.GetMillis()-AliasRequestPeriod*1000-1, MaxAliasRequests), nil)
+		api.On("KVSetWithOptions", aliasRequestsKeyPrefix+"user", countIs(1), mock.Anything).Return(true, nil)
+		api.On("KVSetWithExpiry", pendingAliasKeyPrefix+"user", mock.Anything, AliasCodeTTL).Return(nil)
+
+		_, err := RequestAlias(api, "user", "flast@example.org")
+		require.NoError(t, err)
+	})
+
+	t.Run("concurrent request is retried", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		mockChecks(api)
+		api.On("KVGet", aliasRequestsKeyPrefix+"user").Return(nil, nil).Once()
+		api.On("KVSetWithOptions", aliasRequestsKeyPrefix+"user", countIs(1), mock.Anything).Return(false, nil).Once()
+		api.On("KVGet", aliasRequestsKeyPrefix+"user").Return(requests(model.GetMillis(), MaxAliasRequests), nil).Once()
+
+		_, err := RequestAlias(api, "user", "flast@example.org")
+		assert.Equal(t, ErrTooManyAliasRequests, err)
+	})
+}
+
+func TestVerifyAlias(t *testing.T) {
+	pending, err := json.Marshal(pendingAlias{Address: "flast@example.org", Code: "abcdefghij"})
+	require.NoError(t, err)
+
+	t.Run("correct code registers the alias", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", pendingAliasKeyPrefix+"user").Return(pending, nil)
+		api.On("KVSetWithOptions", aliasKey("flast@example.org"), []byte("user"), model.PluginKVSetOptions{Atomic: true}).Return(true, nil)
+		api.On("KVGet", aliasListKeyPrefix+"user").Return(nil, nil)
+		api.On("KVSet", aliasListKeyPrefix+"user", []byte(`["flast@example.org"]`)).Return(nil)
+		api.On("KVDelete", pendingAliasKeyPrefix+"user").Return(nil)
+
+		address, err := VerifyAlias(api, "user", " ABCDEFGHIJ ")
+		require.NoError(t, err)
+		assert.Equal(t, "flast@example.org", address)
+	})
+
+	t.Run("wrong code counts the attempt", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", pendingAliasKeyPrefix+"user").Return(pending, nil)
+		api.On("KVSetWithExpiry", pendingAliasKeyPrefix+"user", mock.MatchedBy(func(value []byte) bool {
+			var p pendingAlias
+			return json.Unmarshal(value, &p) == nil && p.Attempts == 1
+		}), AliasCodeTTL).Return(nil)
+
+		_, err := VerifyAlias(api, "user", "wrong")
+		assert.Equal(t, ErrInvalidAliasCode, err)
+	})
+}
+
+func TestLookupUser(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	api.On("GetUserByEmail", "flast+work@mail.example.com").Return(nil, &model.AppError{})
+	api.On("KVGet", aliasKey("flast+work@mail.example.com")).Return(nil, nil)
+	api.On("GetUserByEmail", "flast@example.com").Return(nil, &model.AppError{})
+	api.On("KVGet", aliasKey("flast@example.com")).Return([]byte("user"), nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user"}, nil)
+
+	p := &Poller{api: api, settings: Settings{AddressRules: AddressRules{
+		StripPlusTags: true,
+		Domains:       map[string]string{"mail.example.com": "example.com"},
+	}}}
+
+	user, err := p.lookupUser("flast+work@mail.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "user", user.Id)
+}
@@ -0,0 +1,322 @@
+package mailermost
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	auditKeyPrefix    string = "audit_"
+	auditDayLayout    string = "20060102"
+	deferredKeyPrefix string = "deferred_"
+	// AuditRetentionDays is the number of days audit entries are kept for.
+	AuditRetentionDays = 30
+	// auditShards is the number of keys each day of audit entries is spread over, so that
+	// concurrent appends rarely update the same key, and each update stays small.
+	auditShards = 16
+	// maxAuditEntriesPerDay bounds the size of a day of audit entries. The oldest entries of
+	// each shard are dropped first.
+	maxAuditEntriesPerDay   = 5000
+	maxAuditEntriesPerShard = maxAuditEntriesPerDay / auditShards
+	maxAuditWriteAttempts   = 5
+)
+
+// Result is what became of an inbound email.
+type Result string
+
+// The results of processing an inbound email.
+const (
+	// ResultPosted means the reply was posted, or its email command run, and the email deleted.
+	ResultPosted Result = "posted"
+	// ResultRejected means the reply was not posted and the email deleted.
+	ResultRejected Result = "rejected"
+	// ResultDeferred means the reply was not posted yet and the email kept to retry later.
+	ResultDeferred Result = "deferred"
+	// ResultDuplicate means the reply had already been posted and the email was deleted.
+	ResultDuplicate Result = "duplicate"
+	// ResultIgnored means the email is not meant for the plugin and was left alone.
+	ResultIgnored Result = "ignored"
+)
+
+// Reason explains why a reply was not posted.
+type Reason string
+
+// The reasons for not posting a reply.
+const (
+	ReasonNone                    Reason = ""
+	ReasonUnreadable              Reason = "unreadable"
+	ReasonNotAddressed            Reason = "not_addressed"
+	ReasonEmptyText               Reason = "empty_text"
+	ReasonNoSender                Reason = "no_sender"
+	ReasonUnknownUser             Reason = "unknown_user"
+	ReasonDeactivatedUser         Reason = "deactivated_user"
+	ReasonBotUser                 Reason = "bot_user"
+	ReasonGuestsDisabled          Reason = "guests_disabled"
+	ReasonNotTeamMember           Reason = "not_team_member"
+	ReasonMuted                   Reason = "muted"
+	ReasonBatchReply              Reason = "batch_reply"
+	ReasonNoPostID                Reason = "no_post_id"
+	ReasonUnknownPost             Reason = "unknown_post"
+	ReasonNotChannelMember        Reason = "not_channel_member"
+	ReasonArchivedChannel         Reason = "archived_channel"
+	ReasonReadOnlyChannel         Reason = "read_only_channel"
+	ReasonNoPostPermission        Reason = "no_post_permission"
+	ReasonTeamNotServed           Reason = "team_not_served"
+	ReasonUnknownChannelAddress   Reason = "unknown_channel_address"
+	ReasonUnknownRecipient        Reason = "unknown_recipient"
+	ReasonDirectMessageRestricted Reason = "direct_message_restricted"
+	ReasonUnverifiedSender        Reason = "unverified_sender"
+	ReasonTooManyFollowers        Reason = "too_many_followers"
+	ReasonTooLarge                Reason = "too_large"
+	ReasonInternalError           Reason = "internal_error"
+	ReasonPostFailed              Reason = "post_failed"
+)
+
+// outcome describes what became of an inbound email.
+type outcome struct {
+	result Result
+	reason Reason
+	userID string
+	postID string
+}
+
+// reject marks the email to be deleted without posting the reply.
+func (o outcome) reject(reason Reason) outcome {
+	o.result = ResultRejected
+	o.reason = reason
+	return o
+}
+
+// retry marks the email to be kept in the mailbox so that it is processed again on the next
+// poll.
+func (o outcome) retry(reason Reason) outcome {
+	o.result = ResultDeferred
+	o.reason = reason
+	return o
+}
+
+// deletes reports whether the email should be deleted from the mailbox.
+func (o outcome) deletes() bool {
+	return o.result == ResultPosted || o.result == ResultRejected || o.result == ResultDuplicate
+}
+
+// AuditEntry records what became of an inbound email.
+type AuditEntry struct {
+	// Timestamp is the time the email was processed, in milliseconds since the epoch.
+	Timestamp int64  `json:"timestamp"`
+	Account   string `json:"account,omitempty"`
+	MessageID string `json:"message_id"`
+	Sender    string `json:"sender"`
+	// UserID is the Mattermost user the sender was resolved to, if any.
+	UserID string `json:"user_id,omitempty"`
+	// PostID is the post that was replied to, if it could be determined.
+	PostID string `json:"post_id,omitempty"`
+	Result Result `json:"result"`
+	Reason Reason `json:"reason,omitempty"`
+}
+
+// AuditFilter selects audit entries.
+type AuditFilter struct {
+	// UserID selects the entries of a single user. All users are selected if empty.
+	UserID string
+	// Since and Until bound the days of the selected entries, both inclusive. The zero values
+	// select the whole retention period.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of entries returned, newest first.
+	Limit int
+}
+
+// AuditLog stores the outcome of each inbound email in the plugin KV store, in auditShards keys
+// per day. The keys expire after AuditRetentionDays.
+type AuditLog struct {
+	api plugin.API
+}
+
+// NewAuditLog creates a new AuditLog instance.
+func NewAuditLog(api plugin.API) *AuditLog {
+	return &AuditLog{api: api}
+}
+
+func auditKey(day time.Time, shard int) string {
+	return auditKeyPrefix + day.UTC().Format(auditDayLayout) + "_" + strconv.Itoa(shard)
+}
+
+// auditShard returns the shard of the day the entry is stored in.
+func auditShard(entry AuditEntry) int {
+	h := fnv.New32a()
+	h.Write([]byte(entry.MessageID))
+	h.Write([]byte(strconv.FormatInt(entry.Timestamp, 10)))
+	return int(h.Sum32() % auditShards)
+}
+
+// Append adds an entry to the log of the day of its timestamp.
+func (l *AuditLog) Append(entry AuditEntry) error {
+	key := auditKey(time.Unix(0, entry.Timestamp*int64(time.Millisecond)), auditShard(entry))
+
+	// Several workers and nodes may append concurrently, so the shard is updated with a
+	// compare-and-set and retried if another append got in first.
+	for attempt := 0; attempt < maxAuditWriteAttempts; attempt++ {
+		current, appErr := l.api.KVGet(key)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to get audit log %q", key)
+		}
+
+		var entries []AuditEntry
+		if current != nil {
+			if err := json.Unmarshal(current, &entries); err != nil {
+				return errors.Wrapf(err, "failed to decode audit log %q", key)
+			}
+		}
+
+		entries = append(entries, entry)
+		if len(entries) > maxAuditEntriesPerShard {
+			entries = entries[len(entries)-maxAuditEntriesPerShard:]
+		}
+
+		next, err := json.Marshal(entries)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode audit log")
+		}
+
+		saved, appErr := l.api.KVSetWithOptions(key, next, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        current,
+			ExpireInSeconds: int64((AuditRetentionDays + 1) * 24 * time.Hour / time.Second),
+		})
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to set audit log %q", key)
+		}
+		if saved {
+			return nil
+		}
+	}
+
+	return errors.Errorf("failed to append to audit log %q after %d attempts", key, maxAuditWriteAttempts)
+}
+
+// Query returns the entries matching the filter, newest first.
+func (l *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	until := filter.Until
+	if until.IsZero() || until.After(time.Now()) {
+		until = time.Now()
+	}
+	oldest := time.Now().AddDate(0, 0, -AuditRetentionDays)
+	since := filter.Since
+	if since.Before(oldest) {
+		since = oldest
+	}
+	since = truncateDay(since)
+
+	var result []AuditEntry
+	for day := truncateDay(until); !day.Before(since); day = day.AddDate(0, 0, -1) {
+		entries, err := l.day(day)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			if filter.UserID != "" && entries[i].UserID != filter.UserID {
+				continue
+			}
+
+			result = append(result, entries[i])
+			if filter.Limit > 0 && len(result) >= filter.Limit {
+				return result, nil
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// day returns the entries of the given day, oldest first.
+func (l *AuditLog) day(day time.Time) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for shard := 0; shard < auditShards; shard++ {
+		value, appErr := l.api.KVGet(auditKey(day, shard))
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to get audit log of %s", day.Format("2006-01-02"))
+		}
+		if value == nil {
+			continue
+		}
+
+		var shardEntries []AuditEntry
+		if err := json.Unmarshal(value, &shardEntries); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode audit log of %s", day.Format("2006-01-02"))
+		}
+		entries = append(entries, shardEntries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+
+	return entries, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// audit records the outcome of processing an email in the audit log and the metrics. Emails not
+// meant for the plugin are not recorded, and emails deferred again for the same reason on later
+// polls are only recorded the first time.
+func (p *Poller) audit(envelope *imap.Envelope, o outcome) {
+	p.metrics.recordOutcome(p.account.Name, o)
+	if o.result == ResultIgnored {
+		return
+	}
+	if o.result == ResultDeferred && !p.firstDeferral(envelope.MessageId, o.reason) {
+		return
+	}
+
+	entry := AuditEntry{
+		Timestamp: model.GetMillis(),
+		Account:   p.account.Name,
+		MessageID: envelope.MessageId,
+		Sender:    p.senderAddress(envelope),
+		UserID:    o.userID,
+		PostID:    o.postID,
+		Result:    o.result,
+		Reason:    o.reason,
+	}
+	if err := p.auditLog.Append(entry); err != nil {
+		p.api.LogError("Failed to write audit log", "account", p.account.Name, "error", err.Error())
+	}
+}
+
+// firstDeferral reports whether the email is deferred for the given reason for the first time.
+// Emails without a Message-ID cannot be told apart, so each of their deferrals counts as the
+// first.
+func (p *Poller) firstDeferral(messageID string, reason Reason) bool {
+	if messageID == "" {
+		return true
+	}
+
+	sum := sha256.Sum256([]byte(messageID + "\n" + string(reason)))
+	key := deferredKeyPrefix + hex.EncodeToString(sum[:])[:32]
+
+	first, appErr := p.api.KVSetWithOptions(key, []byte(strconv.FormatInt(model.GetMillis(), 10)), model.PluginKVSetOptions{
+		Atomic:          true,
+		ExpireInSeconds: processedTTL,
+	})
+	if appErr != nil {
+		p.api.LogError("Failed to record deferred email", "account", p.account.Name, "error", appErr.Error())
+		return true
+	}
+
+	return first
+}
@@ -0,0 +1,132 @@
+package mailermost
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	now := time.Now()
+
+	t.Run("append retries when another append got in first", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		entry := AuditEntry{Timestamp: model.GetMillisForTime(now), MessageID: "<2@example.org>"}
+		key := auditKey(now, auditShard(entry))
+		existing, err := json.Marshal([]AuditEntry{{MessageID: "<1@example.org>"}})
+		require.NoError(t, err)
+
+		api.On("KVGet", key).Return(nil, nil).Once()
+		api.On("KVSetWithOptions", key, mock.Anything, mock.Anything).Return(false, nil).Once()
+		api.On("KVGet", key).Return(existing, nil).Once()
+		api.On("KVSetWithOptions", key, mock.MatchedBy(func(value []byte) bool {
+			var entries []AuditEntry
+			return json.Unmarshal(value, &entries) == nil && len(entries) == 2 && entries[1].MessageID == "<2@example.org>"
+		}), mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+			return options.Atomic && string(options.OldValue) == string(existing)
+		})).Return(true, nil).Once()
+
+		require.NoError(t, NewAuditLog(api).Append(entry))
+	})
+
+	t.Run("append drops the oldest entries of a full shard", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		entry := AuditEntry{Timestamp: model.GetMillisForTime(now), MessageID: "<new@example.org>"}
+		key := auditKey(now, auditShard(entry))
+		full := make([]AuditEntry, maxAuditEntriesPerShard)
+		for i := range full {
+			full[i].MessageID = fmt.Sprintf("<%d@example.org>", i)
+		}
+		existing, err := json.Marshal(full)
+		require.NoError(t, err)
+
+		api.On("KVGet", key).Return(existing, nil).Once()
+		api.On("KVSetWithOptions", key, mock.MatchedBy(func(value []byte) bool {
+			var entries []AuditEntry
+			return json.Unmarshal(value, &entries) == nil && len(entries) == maxAuditEntriesPerShard &&
+				entries[0].MessageID == "<1@example.org>" && entries[len(entries)-1].MessageID == "<new@example.org>"
+		}), mock.Anything).Return(true, nil).Once()
+
+		require.NoError(t, NewAuditLog(api).Append(entry))
+	})
+
+	t.Run("entries are spread over the shards of the day", func(t *testing.T) {
+		shards := make(map[int]bool)
+		for i := 0; i < 100; i++ {
+			shards[auditShard(AuditEntry{Timestamp: model.GetMillisForTime(now), MessageID: fmt.Sprintf("<%d@example.org>", i)})] = true
+		}
+		assert.Len(t, shards, auditShards)
+	})
+
+	t.Run("query merges the shards and filters by user, newest first", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		shard0, err := json.Marshal([]AuditEntry{
+			{Timestamp: 1, MessageID: "<1@example.org>", UserID: "alice"},
+			{Timestamp: 4, MessageID: "<4@example.org>", UserID: "alice"},
+		})
+		require.NoError(t, err)
+		shard1, err := json.Marshal([]AuditEntry{
+			{Timestamp: 2, MessageID: "<2@example.org>", UserID: "bob"},
+			{Timestamp: 3, MessageID: "<3@example.org>", UserID: "alice"},
+		})
+		require.NoError(t, err)
+
+		api.On("KVGet", auditKey(now, 0)).Return(shard0, nil)
+		api.On("KVGet", auditKey(now, 1)).Return(shard1, nil)
+		api.On("KVGet", mock.Anything).Return(nil, nil)
+
+		result, err := NewAuditLog(api).Query(AuditFilter{UserID: "alice", Since: now.AddDate(0, 0, -1)})
+		require.NoError(t, err)
+		require.Len(t, result, 3)
+		assert.Equal(t, "<4@example.org>", result[0].MessageID)
+		assert.Equal(t, "<3@example.org>", result[1].MessageID)
+		assert.Equal(t, "<1@example.org>", result[2].MessageID)
+
+		result, err = NewAuditLog(api).Query(AuditFilter{Since: now, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, result, 2)
+		assert.Equal(t, "<4@example.org>", result[0].MessageID)
+		assert.Equal(t, "<3@example.org>", result[1].MessageID)
+	})
+}
+
+func TestAuditDeferredOnce(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newTestPoller(t, api)
+	p.auditLog = NewAuditLog(api)
+
+	envelope := &imap.Envelope{MessageId: "<1@example.org>"}
+	firstDeferral := mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+		return options.Atomic && options.OldValue == nil && options.ExpireInSeconds > 0
+	})
+	isDeferral := func(key string) bool { return strings.HasPrefix(key, deferredKeyPrefix) }
+	isAudit := func(key string) bool { return strings.HasPrefix(key, auditKeyPrefix) }
+
+	// The first deferral is recorded, and so is a deferral for another reason.
+	api.On("KVSetWithOptions", mock.MatchedBy(isDeferral), mock.Anything, firstDeferral).Return(true, nil).Once()
+	api.On("KVSetWithOptions", mock.MatchedBy(isDeferral), mock.Anything, firstDeferral).Return(false, nil).Once()
+	api.On("KVSetWithOptions", mock.MatchedBy(isDeferral), mock.Anything, firstDeferral).Return(true, nil).Once()
+	api.On("KVGet", mock.MatchedBy(isAudit)).Return(nil, nil).Times(3)
+	api.On("KVSetWithOptions", mock.MatchedBy(isAudit), mock.Anything, mock.Anything).Return(true, nil).Times(3)
+
+	p.audit(envelope, outcome{}.retry(ReasonPostFailed))
+	p.audit(envelope, outcome{}.retry(ReasonPostFailed))
+	p.audit(envelope, outcome{}.retry(ReasonInternalError))
+	p.audit(envelope, outcome{result: ResultPosted})
+}
@@ -0,0 +1,83 @@
+package mailermost
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// authorizeSender checks that the user the email is from may post at all, and returns why not
+// otherwise. GetUserByEmail also returns deactivated users and bots.
+func (p *Poller) authorizeSender(user *model.User) Reason {
+	if user.DeleteAt != 0 {
+		p.api.LogError(fmt.Sprintf("user %s is deactivated", user.Id))
+		return ReasonDeactivatedUser
+	}
+
+	if user.IsBot || user.Id == p.botUserID {
+		p.api.LogError(fmt.Sprintf("user %s is a bot", user.Id))
+		return ReasonBotUser
+	}
+
+	if user.IsGuest() {
+		enabled := p.api.GetConfig().GuestAccountsSettings.Enable
+		if enabled == nil || !*enabled {
+			p.api.LogError(fmt.Sprintf("user %s is a guest and guest accounts are disabled", user.Id))
+			return ReasonGuestsDisabled
+		}
+	}
+
+	return ReasonNone
+}
+
+// authorizeChannel checks that the user may post in the channel the same way they could in
+// Mattermost, and returns why not otherwise. Read-only and moderated channels are enforced
+// through the create_post permission of the channel scheme.
+func (p *Poller) authorizeChannel(user *model.User, channel *model.Channel) Reason {
+	// Team membership is checked first, as users removed from a team can keep stale channel
+	// memberships.
+	if channel.TeamId != "" {
+		member, appErr := p.api.GetTeamMember(channel.TeamId, user.Id)
+		if appErr != nil || member.DeleteAt != 0 {
+			p.api.LogError(fmt.Sprintf("user %s is not a member of team %s", user.Id, channel.TeamId))
+			return ReasonNotTeamMember
+		}
+	}
+
+	if _, appErr := p.api.GetChannelMember(channel.Id, user.Id); appErr != nil {
+		p.api.LogError(fmt.Sprintf("failed to get channel member %s in channel %s: %s", user.Id, channel.Id, appErr.Error()))
+		return ReasonNotChannelMember
+	}
+
+	if channel.DeleteAt != 0 {
+		p.api.LogError(fmt.Sprintf("channel %s is archived, not posting reply of user %s", channel.Id, user.Id))
+		return ReasonArchivedChannel
+	}
+
+	if p.isReadOnly(user, channel) {
+		p.api.LogError(fmt.Sprintf("channel %s is read-only, not posting reply of user %s", channel.Id, user.Id))
+		return ReasonReadOnlyChannel
+	}
+
+	if !p.api.HasPermissionToChannel(user.Id, channel.Id, model.PERMISSION_CREATE_POST) {
+		p.api.LogError(fmt.Sprintf("user %s has no permission to post in channel %s", user.Id, channel.Id))
+		return ReasonNoPostPermission
+	}
+
+	return ReasonNone
+}
+
+// isReadOnly reports whether the channel is the Town Square of a team and only system admins
+// may post in it, as enforced by the server for licensed installations.
+func (p *Poller) isReadOnly(user *model.User, channel *model.Channel) bool {
+	if channel.Name != model.DEFAULT_CHANNEL || p.api.GetLicense() == nil {
+		return false
+	}
+
+	readOnly := p.api.GetConfig().TeamSettings.ExperimentalTownSquareIsReadOnly
+	if readOnly == nil || !*readOnly {
+		return false
+	}
+
+	return !p.api.HasPermissionTo(user.Id, model.PERMISSION_MANAGE_SYSTEM)
+}
@@ -0,0 +1,81 @@
+package mailermost
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAuthorizeSender(t *testing.T) {
+	guestsEnabled := false
+	config := &model.Config{}
+	config.GuestAccountsSettings.Enable = &guestsEnabled
+
+	for name, test := range map[string]struct {
+		user     *model.User
+		expected Reason
+	}{
+		"active user":     {user: &model.User{Id: "user", Roles: model.SYSTEM_USER_ROLE_ID}, expected: ReasonNone},
+		"deactivated":     {user: &model.User{Id: "user", DeleteAt: 1}, expected: ReasonDeactivatedUser},
+		"bot":             {user: &model.User{Id: "user", IsBot: true}, expected: ReasonBotUser},
+		"plugin bot":      {user: &model.User{Id: "bot"}, expected: ReasonBotUser},
+		"disabled guests": {user: &model.User{Id: "user", Roles: model.SYSTEM_GUEST_ROLE_ID}, expected: ReasonGuestsDisabled},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("LogError", mock.Anything).Maybe()
+			api.On("GetConfig").Return(config)
+
+			p := &Poller{api: api, botUserID: "bot"}
+			assert.Equal(t, test.expected, p.authorizeSender(test.user))
+		})
+	}
+}
+
+func TestAuthorizeChannel(t *testing.T) {
+	user := &model.User{Id: "user"}
+	readOnly := true
+	config := &model.Config{}
+	config.TeamSettings.ExperimentalTownSquareIsReadOnly = &readOnly
+
+	for name, test := range map[string]struct {
+		channel  *model.Channel
+		member   bool
+		licensed bool
+		canPost  bool
+		expected Reason
+	}{
+		"allowed":            {channel: &model.Channel{Id: "channel"}, member: true, canPost: true, expected: ReasonNone},
+		"not a team member":  {channel: &model.Channel{Id: "channel", TeamId: "other"}, member: true, canPost: true, expected: ReasonNotTeamMember},
+		"not a member":       {channel: &model.Channel{Id: "channel"}, expected: ReasonNotChannelMember},
+		"archived":           {channel: &model.Channel{Id: "channel", DeleteAt: 1}, member: true, canPost: true, expected: ReasonArchivedChannel},
+		"read-only":          {channel: &model.Channel{Id: "channel", Name: model.DEFAULT_CHANNEL}, member: true, licensed: true, canPost: true, expected: ReasonReadOnlyChannel},
+		"unlicensed":         {channel: &model.Channel{Id: "channel", Name: model.DEFAULT_CHANNEL}, member: true, canPost: true, expected: ReasonNone},
+		"without permission": {channel: &model.Channel{Id: "channel"}, member: true, expected: ReasonNoPostPermission},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("LogError", mock.Anything).Maybe()
+			if test.member {
+				api.On("GetChannelMember", "channel", "user").Return(&model.ChannelMember{}, nil)
+			} else {
+				api.On("GetChannelMember", "channel", "user").Return(nil, &model.AppError{})
+			}
+			var license *model.License
+			if test.licensed {
+				license = &model.License{}
+			}
+			api.On("GetTeamMember", "other", "user").Return(nil, &model.AppError{})
+			api.On("GetLicense").Return(license)
+			api.On("GetConfig").Return(config)
+			api.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+			api.On("HasPermissionToChannel", "user", "channel", model.PERMISSION_CREATE_POST).Return(test.canPost)
+
+			p := &Poller{api: api}
+			assert.Equal(t, test.expected, p.authorizeChannel(user, test.channel))
+		})
+	}
+}
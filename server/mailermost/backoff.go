@@ -0,0 +1,81 @@
+package mailermost
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	maxBackoff = 30 * time.Minute
+	// breakerThreshold is the number of consecutive authentication failures after which polling
+	// is paused, so a wrong password does not get the account locked by the mail provider.
+	breakerThreshold = 3
+	breakerCooldown  = time.Hour
+)
+
+// backoff computes the delay before the next poll, growing exponentially with the number of
+// consecutive failures.
+type backoff struct {
+	interval time.Duration
+	failures uint
+}
+
+// success resets the failure count and returns the regular polling interval.
+func (b *backoff) success() time.Duration {
+	b.failures = 0
+	return b.interval
+}
+
+// failure records a failed poll and returns the delay before the next attempt. The delay is
+// jittered so that restarted nodes do not retry in lockstep.
+func (b *backoff) failure() time.Duration {
+	if b.failures < 16 {
+		b.failures++
+	}
+
+	delay := b.interval << b.failures
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1)) // #nosec G404 -- jitter does not need a secure source
+}
+
+// circuitBreaker opens after breakerThreshold consecutive authentication failures.
+type circuitBreaker struct {
+	failures int
+	open     bool
+}
+
+// authFailure records an authentication failure and reports whether the breaker just opened.
+func (b *circuitBreaker) authFailure() bool {
+	b.failures++
+	if b.open || b.failures < breakerThreshold {
+		return false
+	}
+
+	b.open = true
+	return true
+}
+
+// success resets the breaker and reports whether it was open.
+func (b *circuitBreaker) success() bool {
+	wasOpen := b.open
+	b.failures = 0
+	b.open = false
+	return wasOpen
+}
+
+// authError marks a failure to log into the IMAP server.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,41 @@
+package mailermost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{interval: 10 * time.Second}
+
+	for i := 1; i <= 20; i++ {
+		delay := b.failure()
+		expected := b.interval << uint(i)
+		if expected > maxBackoff || i > 16 {
+			expected = maxBackoff
+		}
+		assert.True(t, delay >= expected/2 && delay <= expected, "attempt %d: delay %s not within [%s, %s]", i, delay, expected/2, expected)
+	}
+
+	assert.Equal(t, b.interval, b.success())
+	assert.True(t, b.failure() <= 2*b.interval)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{}
+
+	for i := 1; i < breakerThreshold; i++ {
+		assert.False(t, b.authFailure())
+		assert.False(t, b.open)
+	}
+
+	assert.True(t, b.authFailure(), "breaker should open on the last failure")
+	assert.True(t, b.open)
+	assert.False(t, b.authFailure(), "breaker should only report opening once")
+
+	assert.True(t, b.success(), "breaker should report closing")
+	assert.False(t, b.open)
+	assert.False(t, b.success())
+}
@@ -0,0 +1,239 @@
+package mailermost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	channelAddressKeyPrefix string = "chaddr_"
+	channelTokenKeyPrefix   string = "chtoken_"
+
+	// channelAddressTag precedes the token in the +tag of a channel address.
+	channelAddressTag = "ch-"
+
+	// addressTokenLength is the length of the tokens of channel and direct message addresses.
+	addressTokenLength = 16
+	// maxAttachmentsPerPost is the number of file IDs that fit in a post.
+	maxAttachmentsPerPost = 5
+)
+
+// ChannelAddress returns the address that starts posts in the channel with the given token, by
+// adding it as a +tag to the address of an account.
+func ChannelAddress(accountEmail, token string) string {
+	return taggedAddress(accountEmail, channelAddressTag+token)
+}
+
+// NewChannelToken creates the token of a channel address, replacing its previous token.
+func NewChannelToken(api plugin.API, channelID string) (string, error) {
+	if err := RevokeChannelToken(api, channelID); err != nil {
+		return "", err
+	}
+
+	token := model.NewRandomString(addressTokenLength)
+	if appErr := api.KVSet(channelAddressKeyPrefix+token, []byte(channelID)); appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to set channel address of channel %s", channelID)
+	}
+	if appErr := api.KVSet(channelTokenKeyPrefix+channelID, []byte(token)); appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to set channel token of channel %s", channelID)
+	}
+
+	return token, nil
+}
+
+// ChannelToken returns the token of the address of the given channel, or an empty string.
+func ChannelToken(api plugin.API, channelID string) (string, error) {
+	value, appErr := api.KVGet(channelTokenKeyPrefix + channelID)
+	if appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to get channel token of channel %s", channelID)
+	}
+
+	return string(value), nil
+}
+
+// RevokeChannelToken deletes the address of the given channel, if it has one.
+func RevokeChannelToken(api plugin.API, channelID string) error {
+	token, err := ChannelToken(api, channelID)
+	if err != nil || token == "" {
+		return err
+	}
+
+	if appErr := api.KVDelete(channelAddressKeyPrefix + token); appErr != nil {
+		return errors.Wrapf(appErr, "failed to delete channel address of channel %s", channelID)
+	}
+	if appErr := api.KVDelete(channelTokenKeyPrefix + channelID); appErr != nil {
+		return errors.Wrapf(appErr, "failed to delete channel token of channel %s", channelID)
+	}
+
+	return nil
+}
+
+// channelForToken returns the ID of the channel with the given address token, or an empty
+// string.
+func channelForToken(api plugin.API, token string) (string, error) {
+	value, appErr := api.KVGet(channelAddressKeyPrefix + token)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get channel address")
+	}
+
+	return string(value), nil
+}
+
+// addressedChannel returns the channel of the channel address an email was sent to, or why it
+// cannot be posted in.
+func (p *Poller) addressedChannel(email channelEmail) (*model.Channel, Reason, error) {
+	channelID, err := channelForToken(p.api, email.token)
+	if err != nil {
+		return nil, ReasonNone, err
+	}
+	if channelID == "" {
+		p.api.LogError(fmt.Sprintf("email %s was sent to an unknown channel address", email.messageID))
+		return nil, ReasonUnknownChannelAddress, nil
+	}
+
+	channel, appErr := p.api.GetChannel(channelID)
+	if appErr != nil {
+		p.api.LogError(fmt.Sprintf("failed to get channel with id %s: %s", channelID, appErr.Error()))
+		return nil, ReasonInternalError, nil
+	}
+
+	return channel, ReasonNone, nil
+}
+
+// channelEmail is an email sent to a channel address, or to the direct message address of a user.
+type channelEmail struct {
+	messageID string
+	// token is the token of the channel address the email was sent to, if any.
+	token string
+	// directToken is the token of the direct message address the email was sent to, if any.
+	directToken string
+	subject     string
+	text        string
+	attachments []attachment
+	props       model.StringInterface
+	dedupeKey   string
+}
+
+// postToChannel creates a new post from the user in the channel of the address the email was
+// sent to, or in their direct message channel with the user it was sent to, with the subject as
+// a heading and the attachments uploaded. reject rejects the email telling the user why.
+func (p *Poller) postToChannel(o outcome, reject func(Reason) outcome, user *model.User, email channelEmail) outcome {
+	var channel *model.Channel
+	var reason Reason
+	var err error
+	if email.directToken != "" {
+		channel, reason, err = p.directChannel(user, email.directToken)
+	} else {
+		channel, reason, err = p.addressedChannel(email)
+	}
+	if err != nil {
+		p.api.LogError(fmt.Sprintf("failed to get channel of email %s: %s", email.messageID, err.Error()))
+		return o.retry(ReasonInternalError)
+	}
+	if reason != ReasonNone {
+		return reject(reason)
+	}
+	channelID := channel.Id
+
+	// The membership of the user is checked before posting, also in direct message channels
+	// created above.
+	if reason = p.authorizeChannel(user, channel); reason != ReasonNone {
+		return reject(reason)
+	}
+
+	if len(p.account.Teams) > 0 && !p.allowsChannel(user, channel) {
+		p.api.LogError(fmt.Sprintf("channel %s of email %s is not in a team served by account %q", channelID, email.messageID, p.account.Name))
+		return reject(ReasonTeamNotServed)
+	}
+
+	if email.text == "" && len(email.attachments) == 0 {
+		p.api.LogError(fmt.Sprintf("email %s has no message text", email.messageID))
+		return reject(ReasonEmptyText)
+	}
+
+	message := email.text
+	if subject := strings.TrimSpace(email.subject); subject != "" {
+		message = strings.TrimSpace("**" + subject + "**\n\n" + message)
+	}
+
+	fileIDs, err := p.uploadAttachments(channelID, email)
+	if err != nil {
+		p.api.LogError(fmt.Sprintf("failed to upload attachments of email %s: %s", email.messageID, err.Error()))
+		return o.retry(ReasonPostFailed)
+	}
+	if message == "" && len(fileIDs) == 0 {
+		p.api.LogError(fmt.Sprintf("email %s has no message text and none of its attachments can be posted", email.messageID))
+		return reject(ReasonEmptyText)
+	}
+
+	post, err := p.createPosts(&model.Post{
+		UserId:    user.Id,
+		ChannelId: channelID,
+		Message:   message,
+		FileIds:   fileIDs,
+		Props:     email.props,
+	})
+	if err != nil {
+		p.api.LogError(fmt.Sprintf("failed to create post from email %s: %s", email.messageID, err.Error()))
+		// Retrying would upload the attachments again, and leave the files uploaded now unused
+		// each time.
+		if len(fileIDs) > 0 {
+			return reject(ReasonInternalError)
+		}
+		return o.retry(ReasonPostFailed)
+	}
+	o.postID = post.Id
+
+	if err = p.markProcessed(email.dedupeKey); err != nil {
+		p.api.LogError(fmt.Sprintf("failed to mark email %s as posted: %s", email.messageID, err.Error()))
+	}
+
+	o.result = ResultPosted
+	return o
+}
+
+// uploadAttachments uploads the attachments of the email that the server accepts to the channel
+// and returns their file IDs. Attachments are left out if the server does not allow file
+// attachments or they are larger than it accepts. An error is only returned if the first upload
+// fails, so that retrying the email never uploads a file twice. Once a file has been uploaded,
+// attachments that fail to upload are left out.
+func (p *Poller) uploadAttachments(channelID string, email channelEmail) ([]string, error) {
+	if len(email.attachments) == 0 {
+		return nil, nil
+	}
+
+	fileSettings := p.api.GetConfig().FileSettings
+	if fileSettings.EnableFileAttachments != nil && !*fileSettings.EnableFileAttachments {
+		p.api.LogWarn(fmt.Sprintf("file attachments are disabled, posting email %s without its attachments", email.messageID))
+		return nil, nil
+	}
+
+	var fileIDs []string
+	for _, a := range email.attachments {
+		if len(fileIDs) == maxAttachmentsPerPost {
+			p.api.LogWarn(fmt.Sprintf("email %s has more than %d attachments, posting the first ones", email.messageID, maxAttachmentsPerPost))
+			break
+		}
+
+		if fileSettings.MaxFileSize != nil && int64(len(a.data)) > *fileSettings.MaxFileSize {
+			p.api.LogWarn(fmt.Sprintf("attachment %q of email %s is larger than the server accepts, posting the email without it", a.name, email.messageID))
+			continue
+		}
+
+		info, appErr := p.api.UploadFile(a.data, channelID, a.name)
+		if appErr != nil {
+			if len(fileIDs) == 0 {
+				return nil, errors.Wrapf(appErr, "failed to upload attachment %q", a.name)
+			}
+			p.api.LogError(fmt.Sprintf("failed to upload attachment %q of email %s, posting the email without it: %s", a.name, email.messageID, appErr.Error()))
+			continue
+		}
+		fileIDs = append(fileIDs, info.Id)
+	}
+
+	return fileIDs, nil
+}
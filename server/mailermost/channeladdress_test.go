@@ -0,0 +1,139 @@
+package mailermost
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPostToChannelAttachments(t *testing.T) {
+	user := &model.User{Id: "alice"}
+	email := channelEmail{
+		messageID: "<1@example.org>",
+		token:     "lunchtoken",
+		subject:   "Lunch",
+		text:      "Menus attached.",
+		attachments: []attachment{
+			{name: "monday.pdf", data: []byte("monday")},
+			{name: "tuesday.pdf", data: []byte("tuesday")},
+		},
+	}
+	reject := func(reason Reason) outcome {
+		return outcome{}.reject(reason)
+	}
+
+	mockAPI := func(t *testing.T, config *model.Config) *plugintest.API {
+		if config == nil {
+			config = &model.Config{}
+			config.SetDefaults()
+		}
+
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(config).Maybe()
+		api.On("KVGet", channelAddressKeyPrefix+"lunchtoken").Return([]byte("channel"), nil)
+		api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionToChannel", "alice", "channel", model.PERMISSION_CREATE_POST).Return(true).Maybe()
+		return api
+	}
+	withFiles := func(fileIDs ...string) interface{} {
+		return mock.MatchedBy(func(post *model.Post) bool {
+			return assert.ObjectsAreEqual(model.StringArray(fileIDs), post.FileIds)
+		})
+	}
+
+	t.Run("nothing is uploaded before the checks pass", func(t *testing.T) {
+		api := mockAPI(t, nil)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+		api.On("GetChannelMember", "channel", "alice").Return(nil, model.NewAppError("GetChannelMember", "", nil, "", 404)).Once()
+
+		o := p.postToChannel(outcome{}, reject, user, email)
+		assert.Equal(t, ResultRejected, o.result)
+		assert.Equal(t, ReasonNotChannelMember, o.reason)
+		api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("posted with the attachments", func(t *testing.T) {
+		api := mockAPI(t, nil)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+		api.On("GetChannelMember", "channel", "alice").Return(&model.ChannelMember{}, nil)
+		api.On("UploadFile", []byte("monday"), "channel", "monday.pdf").Return(&model.FileInfo{Id: "monday"}, nil).Once()
+		api.On("UploadFile", []byte("tuesday"), "channel", "tuesday.pdf").Return(&model.FileInfo{Id: "tuesday"}, nil).Once()
+		api.On("CreatePost", withFiles("monday", "tuesday")).Return(&model.Post{Id: "post"}, nil).Once()
+		api.On("KVSetWithExpiry", mock.Anything, mock.Anything, processedTTL).Return(nil).Once()
+
+		o := p.postToChannel(outcome{}, reject, user, email)
+		assert.Equal(t, ResultPosted, o.result)
+	})
+
+	t.Run("first upload failing is retried", func(t *testing.T) {
+		api := mockAPI(t, nil)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+		api.On("GetChannelMember", "channel", "alice").Return(&model.ChannelMember{}, nil)
+		api.On("UploadFile", []byte("monday"), "channel", "monday.pdf").Return(nil, model.NewAppError("UploadFile", "", nil, "", 500)).Once()
+
+		o := p.postToChannel(outcome{}, reject, user, email)
+		assert.Equal(t, ResultDeferred, o.result)
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+
+	t.Run("later upload failing is left out", func(t *testing.T) {
+		api := mockAPI(t, nil)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+		api.On("GetChannelMember", "channel", "alice").Return(&model.ChannelMember{}, nil)
+		api.On("UploadFile", []byte("monday"), "channel", "monday.pdf").Return(&model.FileInfo{Id: "monday"}, nil).Once()
+		api.On("UploadFile", []byte("tuesday"), "channel", "tuesday.pdf").Return(nil, model.NewAppError("UploadFile", "", nil, "", 500)).Once()
+		api.On("CreatePost", withFiles("monday")).Return(&model.Post{Id: "post"}, nil).Once()
+		api.On("KVSetWithExpiry", mock.Anything, mock.Anything, processedTTL).Return(nil).Once()
+
+		o := p.postToChannel(outcome{}, reject, user, email)
+		assert.Equal(t, ResultPosted, o.result)
+	})
+
+	t.Run("failed post after uploads is not retried", func(t *testing.T) {
+		api := mockAPI(t, nil)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+		api.On("GetChannelMember", "channel", "alice").Return(&model.ChannelMember{}, nil)
+		api.On("UploadFile", mock.Anything, "channel", mock.Anything).Return(&model.FileInfo{Id: "file"}, nil).Twice()
+		api.On("CreatePost", mock.Anything).Return(nil, model.NewAppError("CreatePost", "", nil, "", 500)).Once()
+
+		o := p.postToChannel(outcome{}, reject, user, email)
+		assert.Equal(t, ResultRejected, o.result)
+		assert.Equal(t, ReasonInternalError, o.reason)
+	})
+
+	t.Run("attachments the server does not accept are left out", func(t *testing.T) {
+		config := &model.Config{}
+		config.SetDefaults()
+		config.FileSettings.MaxFileSize = model.NewInt64(6)
+
+		api := mockAPI(t, config)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+		api.On("GetChannelMember", "channel", "alice").Return(&model.ChannelMember{}, nil)
+		api.On("UploadFile", []byte("monday"), "channel", "monday.pdf").Return(&model.FileInfo{Id: "monday"}, nil).Once()
+		api.On("CreatePost", withFiles("monday")).Return(&model.Post{Id: "post"}, nil).Once()
+		api.On("KVSetWithExpiry", mock.Anything, mock.Anything, processedTTL).Return(nil).Once()
+
+		assert.Equal(t, ResultPosted, p.postToChannel(outcome{}, reject, user, email).result)
+
+		config.FileSettings.EnableFileAttachments = model.NewBool(false)
+		api.On("CreatePost", withFiles()).Return(&model.Post{Id: "post"}, nil).Once()
+		api.On("KVSetWithExpiry", mock.Anything, mock.Anything, processedTTL).Return(nil).Once()
+
+		assert.Equal(t, ResultPosted, p.postToChannel(outcome{}, reject, user, email).result)
+
+		attachmentOnly := email
+		attachmentOnly.subject, attachmentOnly.text = "", ""
+		o := p.postToChannel(outcome{}, reject, user, attachmentOnly)
+		assert.Equal(t, ResultRejected, o.result)
+		assert.Equal(t, ReasonEmptyText, o.reason)
+	})
+}
@@ -0,0 +1,52 @@
+package mailermost
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	processedKeyPrefix string = "processed_"
+	// processedTTL bounds how long an email is remembered after it has been posted. It only needs
+	// to outlive the window in which a failed delete can cause the same email to be fetched again.
+	processedTTL int64 = 7 * 24 * 60 * 60
+)
+
+// processedKey returns the KV store key recording that an email has been posted. Emails are
+// identified by their Message-ID, or by a hash of their body when the Message-ID is missing.
+// The key is hashed to stay within the KV store key length limit.
+func processedKey(messageID string, body []byte) string {
+	h := sha256.New()
+	if messageID != "" {
+		h.Write([]byte("id:" + messageID))
+	} else {
+		h.Write([]byte("body:"))
+		h.Write(body)
+	}
+
+	return processedKeyPrefix + hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// isProcessed reports whether the email identified by key has already been posted.
+func (p *Poller) isProcessed(key string) (bool, error) {
+	value, appErr := p.api.KVGet(key)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to get processed marker %q", key)
+	}
+
+	return value != nil, nil
+}
+
+// markProcessed records that the email identified by key has been posted.
+func (p *Poller) markProcessed(key string) error {
+	value := []byte(strconv.FormatInt(model.GetMillis(), 10))
+	if appErr := p.api.KVSetWithExpiry(key, value, processedTTL); appErr != nil {
+		return errors.Wrapf(appErr, "failed to set processed marker %q", key)
+	}
+
+	return nil
+}
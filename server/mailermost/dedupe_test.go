@@ -0,0 +1,72 @@
+package mailermost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessedKey(t *testing.T) {
+	key := processedKey("<1@example.org>", []byte("Lunch at noon?"))
+	assert.True(t, strings.HasPrefix(key, processedKeyPrefix))
+	assert.Len(t, key, len(processedKeyPrefix)+32)
+
+	// Emails with a Message-ID are identified by it alone.
+	assert.Equal(t, key, processedKey("<1@example.org>", []byte("Lunch at one?")))
+	assert.NotEqual(t, key, processedKey("<2@example.org>", []byte("Lunch at noon?")))
+
+	// Emails without one are identified by their body.
+	assert.Equal(t, processedKey("", []byte("Lunch at noon?")), processedKey("", []byte("Lunch at noon?")))
+	assert.NotEqual(t, processedKey("", []byte("Lunch at noon?")), processedKey("", []byte("Lunch at one?")))
+
+	// A body never collides with a Message-ID of the same text.
+	assert.NotEqual(t, processedKey("Lunch at noon?", nil), processedKey("", []byte("Lunch at noon?")))
+}
+
+func TestIsProcessed(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := &Poller{api: api}
+
+	key := processedKey("<1@example.org>", nil)
+	api.On("KVGet", key).Return(nil, nil).Once()
+	processed, err := p.isProcessed(key)
+	require.NoError(t, err)
+	assert.False(t, processed)
+
+	api.On("KVSetWithExpiry", key, mock.Anything, processedTTL).Return(nil).Once()
+	require.NoError(t, p.markProcessed(key))
+	assert.Equal(t, int64(7*24*60*60), processedTTL)
+
+	api.On("KVGet", key).Return([]byte("1"), nil).Once()
+	processed, err = p.isProcessed(key)
+	require.NoError(t, err)
+	assert.True(t, processed)
+
+	api.On("KVGet", key).Return(nil, model.NewAppError("KVGet", "", nil, "", 500)).Once()
+	_, err = p.isProcessed(key)
+	assert.Error(t, err)
+
+	api.On("KVSetWithExpiry", key, mock.Anything, processedTTL).Return(model.NewAppError("KVSetWithExpiry", "", nil, "", 500)).Once()
+	assert.Error(t, p.markProcessed(key))
+}
+
+func TestProcessEmailDuplicate(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := newTestPoller(t, api)
+
+	email := testEmail(1, "replies+ch-abc123@example.org", "", "Noon?")
+	api.On("KVGet", processedKey(email.envelope.MessageId, nil)).Return([]byte("1"), nil).Once()
+
+	o := p.processEmail(email)
+	assert.Equal(t, ResultDuplicate, o.result)
+	assert.True(t, o.deletes())
+	api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,134 @@
+package mailermost
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	directAddressKeyPrefix string = "dmaddr_"
+	directTokenKeyPrefix   string = "dmtoken_"
+
+	// directAddressTag precedes the token in the +tag of a direct message address.
+	directAddressTag = "dm-"
+)
+
+// DirectAddress returns the address that sends direct messages to the user with the given
+// token, by adding it as a +tag to the address of an account.
+func DirectAddress(accountEmail, token string) string {
+	return taggedAddress(accountEmail, directAddressTag+token)
+}
+
+// DirectToken returns the token of the direct message address of the given user, creating it
+// if the user has none. The token is random, so that the address cannot be guessed from the
+// username.
+func DirectToken(api plugin.API, userID string) (string, error) {
+	value, appErr := api.KVGet(directTokenKeyPrefix + userID)
+	if appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to get direct message token of user %s", userID)
+	}
+	if value != nil {
+		return string(value), nil
+	}
+
+	return NewDirectToken(api, userID)
+}
+
+// NewDirectToken creates the token of the direct message address of the given user, replacing
+// their previous token.
+func NewDirectToken(api plugin.API, userID string) (string, error) {
+	previous, appErr := api.KVGet(directTokenKeyPrefix + userID)
+	if appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to get direct message token of user %s", userID)
+	}
+	if previous != nil {
+		if appErr = api.KVDelete(directAddressKeyPrefix + string(previous)); appErr != nil {
+			return "", errors.Wrapf(appErr, "failed to delete direct message address of user %s", userID)
+		}
+	}
+
+	token := model.NewRandomString(addressTokenLength)
+	if appErr = api.KVSet(directAddressKeyPrefix+token, []byte(userID)); appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to set direct message address of user %s", userID)
+	}
+	if appErr = api.KVSet(directTokenKeyPrefix+userID, []byte(token)); appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to set direct message token of user %s", userID)
+	}
+
+	return token, nil
+}
+
+// userForDirectToken returns the ID of the user with the given direct message address token,
+// or an empty string.
+func userForDirectToken(api plugin.API, token string) (string, error) {
+	value, appErr := api.KVGet(directAddressKeyPrefix + token)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get direct message address")
+	}
+
+	return string(value), nil
+}
+
+// directChannel returns the direct message channel of the user with the user with the given
+// direct message address token, creating it if needed, or why the user cannot send them a
+// direct message.
+func (p *Poller) directChannel(user *model.User, token string) (*model.Channel, Reason, error) {
+	recipientID, err := userForDirectToken(p.api, token)
+	if err != nil {
+		return nil, ReasonNone, err
+	}
+	if recipientID == "" {
+		p.api.LogError("unknown direct message address")
+		return nil, ReasonUnknownRecipient, nil
+	}
+
+	recipient, appErr := p.api.GetUser(recipientID)
+	if appErr != nil || recipient.DeleteAt != 0 {
+		p.api.LogError(fmt.Sprintf("no active user with id %s to send a direct message to", recipientID))
+		return nil, ReasonUnknownRecipient, nil
+	}
+
+	restrict := p.api.GetConfig().TeamSettings.RestrictDirectMessage
+	if restrict != nil && *restrict == model.DIRECT_MESSAGE_TEAM && recipient.Id != user.Id {
+		shared, err := p.shareTeam(user.Id, recipient.Id)
+		if err != nil {
+			return nil, ReasonNone, err
+		}
+		if !shared {
+			p.api.LogError(fmt.Sprintf("user %s shares no team with user %s and direct messages are restricted to teams", user.Id, recipient.Id))
+			return nil, ReasonDirectMessageRestricted, nil
+		}
+	}
+
+	channel, appErr := p.api.GetDirectChannel(user.Id, recipient.Id)
+	if appErr != nil {
+		return nil, ReasonNone, errors.Wrapf(appErr, "failed to get direct channel of users %s and %s", user.Id, recipient.Id)
+	}
+
+	return channel, ReasonNone, nil
+}
+
+// shareTeam reports whether two users are members of a common team.
+func (p *Poller) shareTeam(userID, otherUserID string) (bool, error) {
+	teams, appErr := p.api.GetTeamsForUser(userID)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to get teams of user %s", userID)
+	}
+	otherTeams, appErr := p.api.GetTeamsForUser(otherUserID)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to get teams of user %s", otherUserID)
+	}
+
+	for _, team := range teams {
+		for _, otherTeam := range otherTeams {
+			if team.Id == otherTeam.Id {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,111 @@
+package mailermost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectChannel(t *testing.T) {
+	user := &model.User{Id: "user"}
+	channel := &model.Channel{Id: "dm", Type: model.CHANNEL_DIRECT}
+
+	for name, test := range map[string]struct {
+		username string
+		restrict string
+		expected Reason
+	}{
+		"any user":           {username: "alice", restrict: model.DIRECT_MESSAGE_ANY, expected: ReasonNone},
+		"team member":        {username: "alice", restrict: model.DIRECT_MESSAGE_TEAM, expected: ReasonNone},
+		"other team":         {username: "bob", restrict: model.DIRECT_MESSAGE_TEAM, expected: ReasonDirectMessageRestricted},
+		"other team allowed": {username: "bob", restrict: model.DIRECT_MESSAGE_ANY, expected: ReasonNone},
+		"deactivated":        {username: "carol", restrict: model.DIRECT_MESSAGE_ANY, expected: ReasonUnknownRecipient},
+		"unknown":            {username: "dave", restrict: model.DIRECT_MESSAGE_ANY, expected: ReasonUnknownRecipient},
+		"unknown token":      {username: "erin", restrict: model.DIRECT_MESSAGE_ANY, expected: ReasonUnknownRecipient},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := &model.Config{}
+			config.TeamSettings.RestrictDirectMessage = model.NewString(test.restrict)
+
+			api := &plugintest.API{}
+			api.On("LogError", mock.Anything).Maybe()
+			api.On("GetConfig").Return(config)
+			for _, username := range []string{"alice", "bob", "carol", "dave"} {
+				api.On("KVGet", directAddressKeyPrefix+username+"-token").Return([]byte(username), nil)
+			}
+			api.On("KVGet", mock.Anything).Return(nil, nil)
+			api.On("GetUser", "alice").Return(&model.User{Id: "alice"}, nil)
+			api.On("GetUser", "bob").Return(&model.User{Id: "bob"}, nil)
+			api.On("GetUser", "carol").Return(&model.User{Id: "carol", DeleteAt: 1}, nil)
+			api.On("GetUser", "dave").Return(nil, &model.AppError{})
+			api.On("GetTeamsForUser", "user").Return([]*model.Team{{Id: "team"}}, nil)
+			api.On("GetTeamsForUser", "alice").Return([]*model.Team{{Id: "other"}, {Id: "team"}}, nil)
+			api.On("GetTeamsForUser", "bob").Return([]*model.Team{{Id: "other"}}, nil)
+			api.On("GetDirectChannel", "user", mock.Anything).Return(channel, nil)
+
+			p := &Poller{api: api}
+			dm, reason, err := p.directChannel(user, test.username+"-token")
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, reason)
+			if reason == ReasonNone {
+				assert.Equal(t, channel, dm)
+			}
+		})
+	}
+}
+
+func TestDirectAddress(t *testing.T) {
+	assert.Equal(t, "replies+dm-abc123@example.org", DirectAddress("replies@example.org", "abc123"))
+	assert.Equal(t, "", DirectAddress("replies", "abc123"))
+}
+
+func TestDirectToken(t *testing.T) {
+	t.Run("created on first use", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		var token string
+		api.On("KVGet", directTokenKeyPrefix+"alice").Return(nil, nil).Twice()
+		api.On("KVSet", mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, directAddressKeyPrefix)
+		}), []byte("alice")).Run(func(args mock.Arguments) {
+			token = strings.TrimPrefix(args.String(0), directAddressKeyPrefix)
+		}).Return(nil).Once()
+		api.On("KVSet", directTokenKeyPrefix+"alice", mock.Anything).Return(nil).Once()
+
+		created, err := DirectToken(api, "alice")
+		require.NoError(t, err)
+		assert.Len(t, created, addressTokenLength)
+		assert.Equal(t, token, created)
+	})
+
+	t.Run("existing token", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", directTokenKeyPrefix+"alice").Return([]byte("abc123"), nil).Once()
+
+		token, err := DirectToken(api, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, "abc123", token)
+	})
+
+	t.Run("replaced token", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", directTokenKeyPrefix+"alice").Return([]byte("abc123"), nil).Once()
+		api.On("KVDelete", directAddressKeyPrefix+"abc123").Return(nil).Once()
+		api.On("KVSet", mock.Anything, []byte("alice")).Return(nil).Once()
+		api.On("KVSet", directTokenKeyPrefix+"alice", mock.Anything).Return(nil).Once()
+
+		token, err := NewDirectToken(api, "alice")
+		require.NoError(t, err)
+		assert.NotEqual(t, "abc123", token)
+	})
+}
@@ -0,0 +1,259 @@
+package mailermost
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"time"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/pkg/errors"
+)
+
+// recipientSection is the part of an email fetched to tell whether it is addressed to the
+// account before fetching the rest of it.
+var recipientSection = &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: recipientHeaders}}
+
+// fetchEmails fetches the emails with the given sequence numbers from the selected folder. Emails
+// are fetched without marking them as seen, as those not addressed to the account belong to
+// someone else. These are not fetched in full, their UIDs are returned in ignored instead.
+//
+// Emails larger than the maximum message size are not fetched in full. Only their headers and
+// first text part are fetched, which is enough to post the reply without the attachments. If
+// that is still too large, the sender is told that the reply was not posted, and the email is
+// UID returned in rejected so it can be deleted.
+func (p *Poller) fetchEmails(c *client.Client, seqNums []uint32) (emails []*inboundEmail, rejected, ignored []uint32, err error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(seqNums...)
+
+	defer p.metrics.observeIMAP("fetch", time.Now())
+
+	metadata := make(chan *imap.Message, len(seqNums))
+	if err = c.Fetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchRFC822Size, imap.FetchBodyStructure, recipientSection.FetchItem()}, metadata); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to fetch email sizes")
+	}
+
+	full := new(imap.SeqSet)
+	var large []*imap.Message
+	for msg := range metadata {
+		if !p.addressedToAccount(msg) {
+			p.api.LogDebug(fmt.Sprintf("email %s is not addressed to %s, leaving it in the mailbox", msg.Envelope.MessageId, p.account.Email))
+			p.audit(msg.Envelope, outcome{result: ResultIgnored, reason: ReasonNotAddressed})
+			ignored = append(ignored, msg.Uid)
+			continue
+		}
+
+		if p.settings.MaxMessageSize == 0 || msg.Size <= p.settings.MaxMessageSize {
+			full.AddNum(msg.SeqNum)
+		} else {
+			large = append(large, msg)
+		}
+	}
+
+	if !full.Empty() {
+		section := &imap.BodySectionName{Peek: true}
+		messages := make(chan *imap.Message, len(seqNums))
+		if err = c.Fetch(full, []imap.FetchItem{imap.FetchUid, section.FetchItem(), imap.FetchEnvelope}, messages); err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to fetch emails")
+		}
+
+		for msg := range messages {
+			raw, readErr := readSection(msg, section)
+			if readErr != nil {
+				p.api.LogError(fmt.Sprintf("failed to read email %s: %s", msg.Envelope.MessageId, readErr.Error()))
+				continue
+			}
+
+			emails = append(emails, &inboundEmail{uid: msg.Uid, envelope: msg.Envelope, raw: raw})
+		}
+	}
+
+	textEmails, rejected := p.fetchLargeEmails(large, func(msg *imap.Message) (*inboundEmail, error) {
+		return p.fetchTextPart(c, msg)
+	})
+
+	return append(emails, textEmails...), rejected, ignored, nil
+}
+
+// fetchLargeEmails fetches the text of emails larger than the maximum message size with
+// fetchText. Emails without a text part within that size are bounced and returned in rejected.
+// An email whose text fails to fetch is left in the mailbox for the next poll, without holding
+// up the rest.
+func (p *Poller) fetchLargeEmails(large []*imap.Message, fetchText func(msg *imap.Message) (*inboundEmail, error)) (emails []*inboundEmail, rejected []uint32) {
+	for _, msg := range large {
+		email, err := fetchText(msg)
+		if err != nil {
+			p.api.LogError(fmt.Sprintf("failed to fetch email %s, skipping it: %s", msg.Envelope.MessageId, err.Error()))
+			p.audit(msg.Envelope, outcome{}.retry(ReasonUnreadable))
+			continue
+		}
+		if email == nil {
+			p.bounceOversized(msg)
+			rejected = append(rejected, msg.Uid)
+			continue
+		}
+
+		emails = append(emails, email)
+	}
+
+	return emails, rejected
+}
+
+// addressedToAccount reports whether the email with the fetched recipient headers is addressed
+// to the account. Emails whose headers cannot be read are fetched in full and checked again there.
+func (p *Poller) addressedToAccount(msg *imap.Message) bool {
+	raw, err := readSection(msg, recipientSection)
+	if err != nil {
+		return true
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return true
+	}
+
+	return isForAccount(parsed.Header, p.account.Email)
+}
+
+// fetchTextPart fetches the headers and the first text part of a multipart email, leaving out
+// its attachments. The returned email is a single part email with the text part as its body,
+// keeping the headers of the email but the content type and encoding of the part. It returns
+// nil if the email has no text part within the maximum message size.
+func (p *Poller) fetchTextPart(c *client.Client, msg *imap.Message) (*inboundEmail, error) {
+	if msg.BodyStructure == nil || len(msg.BodyStructure.Parts) == 0 {
+		return nil, nil
+	}
+
+	var textPath []int
+	var textPart *imap.BodyStructure
+	msg.BodyStructure.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if !strings.EqualFold(part.MIMEType, "text") || strings.EqualFold(part.Disposition, "attachment") {
+			return true
+		}
+
+		// Prefer plain text over HTML, but fall back to the first text part.
+		if textPart == nil || (strings.EqualFold(part.MIMESubType, "plain") && !strings.EqualFold(textPart.MIMESubType, "plain")) {
+			textPath, textPart = path, part
+		}
+		return true
+	})
+	if textPart == nil || textPart.Size > p.settings.MaxMessageSize {
+		return nil, nil
+	}
+
+	header := &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}}
+	mimeHeader := &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Specifier: imap.MIMESpecifier, Path: textPath}}
+	text := &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Path: textPath}}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(msg.SeqNum)
+
+	messages := make(chan *imap.Message, 1)
+	if err := c.Fetch(seqset, []imap.FetchItem{header.FetchItem(), mimeHeader.FetchItem(), text.FetchItem()}, messages); err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch text of email %s", msg.Envelope.MessageId)
+	}
+
+	fetched := <-messages
+	if fetched == nil {
+		return nil, errors.Errorf("email %s disappeared while fetching its text", msg.Envelope.MessageId)
+	}
+
+	var sections [][]byte
+	for _, section := range []*imap.BodySectionName{header, mimeHeader, text} {
+		b, err := readSection(fetched, section)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read text of email %s", msg.Envelope.MessageId)
+		}
+		sections = append(sections, b)
+	}
+
+	return &inboundEmail{uid: msg.Uid, envelope: msg.Envelope, raw: textPartEmail(sections[0], sections[1], sections[2])}, nil
+}
+
+// textPartEmail lays out an email with the given header and a single part of it as its body.
+// The content header fields of the email, which describe the multipart body, are replaced with
+// those of the part, so that the text is decoded like that of a single part email.
+func textPartEmail(header, partHeader, text []byte) []byte {
+	var raw bytes.Buffer
+	writeHeaderFields(&raw, header, false)
+	writeHeaderFields(&raw, partHeader, true)
+	raw.WriteString("\r\n")
+	raw.Write(text)
+
+	return raw.Bytes()
+}
+
+// writeHeaderFields writes the content header fields in header, or all the other ones, up to
+// the blank line ending the header.
+func writeHeaderFields(w *bytes.Buffer, header []byte, content bool) {
+	keep := false
+	for _, line := range strings.Split(string(header), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			return
+		}
+
+		// Lines starting with white space continue the field above.
+		if line[0] != ' ' && line[0] != '\t' {
+			name := line
+			if colon := strings.Index(line, ":"); colon >= 0 {
+				name = line[:colon]
+			}
+			keep = isContentHeaderField(name) == content
+		}
+		if keep {
+			w.WriteString(line + "\r\n")
+		}
+	}
+}
+
+// isContentHeaderField reports whether the header field describes the body it precedes.
+func isContentHeaderField(name string) bool {
+	name = strings.TrimSpace(name)
+	return strings.EqualFold(name, "Content-Type") || strings.EqualFold(name, "Content-Transfer-Encoding")
+}
+
+func readSection(msg *imap.Message, section *imap.BodySectionName) ([]byte, error) {
+	r := msg.GetBody(section)
+	if r == nil {
+		return nil, errors.New("section missing from fetch response")
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// bounceOversized tells the sender of an email that is too large that their reply was not
+// posted. Only Mattermost users are told, so that spam with forged senders is not bounced.
+func (p *Poller) bounceOversized(msg *imap.Message) {
+	messageID := msg.Envelope.MessageId
+	p.api.LogError(fmt.Sprintf("email %s of %d bytes exceeds the maximum size of %d bytes", messageID, msg.Size, p.settings.MaxMessageSize))
+
+	o := outcome{}.reject(ReasonTooLarge)
+	defer func() {
+		p.audit(msg.Envelope, o)
+	}()
+
+	user, err := p.lookupUser(p.senderAddress(msg.Envelope))
+	if err != nil {
+		p.api.LogError(fmt.Sprintf("failed to look up sender of email %s: %s", messageID, err.Error()))
+		return
+	}
+	if user == nil {
+		return
+	}
+	o.userID = user.Id
+
+	p.noticeRejected(user, rejection{reason: ReasonTooLarge, subject: msg.Envelope.Subject, size: msg.Size})
+}
+
+func formatSize(size uint32) string {
+	const mb = 1024 * 1024
+	if size >= mb {
+		return fmt.Sprintf("%.1f MB", float64(size)/mb)
+	}
+
+	return fmt.Sprintf("%d KB", (size+1023)/1024)
+}
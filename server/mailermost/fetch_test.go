@@ -0,0 +1,246 @@
+package mailermost
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchLargeEmails(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := &Poller{api: api, auditLog: NewAuditLog(api)}
+
+	failing := &imap.Message{Uid: 1, Envelope: &imap.Envelope{MessageId: "<1@example.org>"}}
+	fetched := &imap.Message{Uid: 2, Envelope: &imap.Envelope{MessageId: "<2@example.org>"}}
+
+	api.On("LogError", mock.MatchedBy(func(msg string) bool {
+		return msg == "failed to fetch email <1@example.org>, skipping it: connection reset"
+	})).Once()
+	api.On("KVSetWithOptions", mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, deferredKeyPrefix)
+	}), mock.Anything, mock.Anything).Return(true, nil).Once()
+	api.On("KVGet", mock.Anything).Return(nil, nil).Once()
+	api.On("KVSetWithOptions", mock.Anything, mock.MatchedBy(func(value []byte) bool {
+		return strings.Contains(string(value), `"result":"deferred","reason":"unreadable"`)
+	}), mock.Anything).Return(true, nil).Once()
+
+	emails, rejected := p.fetchLargeEmails([]*imap.Message{failing, fetched}, func(msg *imap.Message) (*inboundEmail, error) {
+		if msg == failing {
+			return nil, errors.New("connection reset")
+		}
+		return &inboundEmail{uid: msg.Uid, envelope: msg.Envelope}, nil
+	})
+
+	require.Len(t, emails, 1)
+	assert.Equal(t, uint32(2), emails[0].uid)
+	assert.Empty(t, rejected)
+}
+
+func TestFetchTextPart(t *testing.T) {
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := client.Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer c.Logout()
+	require.NoError(t, c.Login("username", "password"))
+
+	raw := "From: alice@example.org\r\n" +
+		"To: replies+ch-abc123@example.org\r\n" +
+		"Subject: Lunch\r\n" +
+		"Message-ID: <1@example.org>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed;\r\n" +
+		"\tboundary=\"outer\"\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"THVuY2ggYXQgbm9vbj8=\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"menu.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQK\r\n" +
+		"--outer--\r\n"
+	require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(raw)))
+
+	mbox, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(mbox.Messages)
+	messages := make(chan *imap.Message, 1)
+	require.NoError(t, c.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchRFC822Size, imap.FetchBodyStructure}, messages))
+	msg := <-messages
+	require.NotNil(t, msg)
+
+	p := &Poller{settings: Settings{MaxMessageSize: 1024}}
+	email, err := p.fetchTextPart(c, msg)
+	require.NoError(t, err)
+	require.NotNil(t, email)
+
+	header, body, err := parseEmail(email.raw)
+	require.NoError(t, err)
+	assert.Equal(t, "Lunch", header.Get("Subject"))
+	assert.Equal(t, "base64", header.Get("Content-Transfer-Encoding"))
+
+	text, attachments, err := parseBody(header, body)
+	require.NoError(t, err)
+	assert.Equal(t, "Lunch at noon?", text)
+	assert.Empty(t, attachments)
+}
+
+func TestTextPartEmail(t *testing.T) {
+	header := "Subject: Lunch\r\nContent-Type: multipart/alternative;\r\n boundary=b\r\nX-Mailer: Mail\r\n\r\n"
+	partHeader := "Content-Type: text/plain\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n"
+
+	assert.Equal(t,
+		"Subject: Lunch\r\nX-Mailer: Mail\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nNoon=3F",
+		string(textPartEmail([]byte(header), []byte(partHeader), []byte("Noon=3F"))))
+}
+
+func TestDeleteMessages(t *testing.T) {
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := client.Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer c.Logout()
+	require.NoError(t, c.Login("username", "password"))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString("Subject: Lunch\r\n\r\nLunch at noon?\r\n")))
+	}
+	status, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+	require.True(t, status.Messages >= 3)
+
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, status.Messages)
+	messages := make(chan *imap.Message, status.Messages)
+	require.NoError(t, c.Fetch(seqset, []imap.FetchItem{imap.FetchUid}, messages))
+	var uids []uint32
+	for msg := range messages {
+		uids = append(uids, msg.Uid)
+	}
+
+	// Only the given emails are flagged, whatever their sequence numbers are.
+	p := &Poller{}
+	deleted := uids[len(uids)-2]
+	require.NoError(t, p.deleteMessages(c, []uint32{deleted}))
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uids...)
+	messages = make(chan *imap.Message, len(uids))
+	require.NoError(t, c.UidFetch(uidset, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, messages))
+	for msg := range messages {
+		if msg.Uid == deleted {
+			assert.Contains(t, msg.Flags, imap.DeletedFlag)
+		} else {
+			assert.NotContains(t, msg.Flags, imap.DeletedFlag)
+		}
+	}
+}
+
+func TestNewIMAPClientTimeouts(t *testing.T) {
+	defer func(dial, command time.Duration) {
+		imapDialTimeout, imapCommandTimeout = dial, command
+	}(imapDialTimeout, imapCommandTimeout)
+	imapDialTimeout = 100 * time.Millisecond
+	imapCommandTimeout = 100 * time.Millisecond
+
+	// stalledServer accepts connections, sends them the given greeting, and then stops
+	// responding.
+	stalledServer := func(t *testing.T, greeting string) net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(greeting))
+			}
+		}()
+
+		return l
+	}
+
+	t.Run("greeting", func(t *testing.T) {
+		l := stalledServer(t, "")
+		defer l.Close()
+
+		start := time.Now()
+		_, err := newIMAPClient(l.Addr().String(), securityNone)
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		l := stalledServer(t, "* OK IMAP4rev1 ready\r\n")
+		defer l.Close()
+
+		start := time.Now()
+		c, err := newIMAPClient(l.Addr().String(), securityNone)
+		if err == nil {
+			_, err = c.Capability()
+		}
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		l := stalledServer(t, "* OK [CAPABILITY IMAP4rev1] ready\r\n")
+		defer l.Close()
+
+		c, err := newIMAPClient(l.Addr().String(), securityNone)
+		require.NoError(t, err)
+
+		start := time.Now()
+		assert.Error(t, c.Noop())
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		s := server.New(memory.New())
+		s.AllowInsecureAuth = true
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go s.Serve(l)
+		defer s.Close()
+
+		c, err := newIMAPClient(l.Addr().String(), securityNone)
+		require.NoError(t, err)
+		defer c.Logout()
+		require.NoError(t, c.Login("username", "password"))
+
+		// The connection survives emails being processed between two commands.
+		require.NoError(t, c.idle())
+		time.Sleep(3 * imapCommandTimeout)
+		assert.NoError(t, c.Noop())
+	})
+}
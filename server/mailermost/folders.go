@@ -0,0 +1,125 @@
+package mailermost
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/pkg/errors"
+)
+
+const defaultFolder string = "INBOX"
+
+// FolderStatus describes the outcome of the last check of a mailbox folder.
+type FolderStatus struct {
+	Name      string
+	LastCheck time.Time
+	Messages  uint32
+	LastError string
+}
+
+// ParseFolders splits a comma-separated list of folder names, dropping empty entries. It
+// returns the default folder if the list is empty.
+func ParseFolders(list string) []string {
+	var folders []string
+	for _, folder := range strings.Split(list, ",") {
+		folder = strings.TrimSpace(folder)
+		if folder != "" {
+			folders = append(folders, folder)
+		}
+	}
+
+	if len(folders) == 0 {
+		return []string{defaultFolder}
+	}
+
+	return folders
+}
+
+// resolveFolders expands the IMAP wildcards in the configured folders using LIST and returns
+// the selectable folders in configuration order, without duplicates.
+func (p *Poller) resolveFolders(c *client.Client) ([]string, error) {
+	var folders []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			folders = append(folders, name)
+		}
+	}
+
+	for _, pattern := range p.folders {
+		if !strings.ContainsAny(pattern, "*%") {
+			add(pattern)
+			continue
+		}
+
+		mailboxes := make(chan *imap.MailboxInfo, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.List("", pattern, mailboxes)
+		}()
+
+		var matches []string
+		for mailbox := range mailboxes {
+			if !hasAttribute(mailbox.Attributes, imap.NoSelectAttr) {
+				matches = append(matches, mailbox.Name)
+			}
+		}
+		if err := <-done; err != nil {
+			return nil, errors.Wrapf(err, "failed to list folders matching %q", pattern)
+		}
+
+		sort.Strings(matches)
+		for _, name := range matches {
+			add(name)
+		}
+	}
+
+	return folders, nil
+}
+
+func hasAttribute(attributes []string, attribute string) bool {
+	for _, a := range attributes {
+		if strings.EqualFold(a, attribute) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setFolderStatus records the outcome of checking a folder.
+func (p *Poller) setFolderStatus(name string, messages uint32, err error) {
+	status := FolderStatus{
+		Name:      name,
+		LastCheck: time.Now(),
+		Messages:  messages,
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+
+	p.folderStatuses[name] = status
+}
+
+// FolderStatuses returns the outcome of the last check of each folder, sorted by name.
+func (p *Poller) FolderStatuses() []FolderStatus {
+	p.statusLock.RLock()
+	defer p.statusLock.RUnlock()
+
+	statuses := make([]FolderStatus, 0, len(p.folderStatuses))
+	for _, status := range p.folderStatuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
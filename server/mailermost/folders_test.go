@@ -0,0 +1,103 @@
+package mailermost
+
+import (
+	"net"
+	"testing"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noselectBackend is a memory backend whose folders with the given names cannot be selected,
+// like the parent folders of some servers.
+type noselectBackend struct {
+	backend.Backend
+	noselect map[string]bool
+}
+
+func (b *noselectBackend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &noselectUser{User: user, noselect: b.noselect}, nil
+}
+
+type noselectUser struct {
+	backend.User
+	noselect map[string]bool
+}
+
+func (u *noselectUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	mailboxes, err := u.User.ListMailboxes(subscribed)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, mailbox := range mailboxes {
+		if u.noselect[mailbox.Name()] {
+			mailboxes[i] = &noselectMailbox{Mailbox: mailbox}
+		}
+	}
+	return mailboxes, nil
+}
+
+type noselectMailbox struct {
+	backend.Mailbox
+}
+
+func (m *noselectMailbox) Info() (*imap.MailboxInfo, error) {
+	info, err := m.Mailbox.Info()
+	if err != nil {
+		return nil, err
+	}
+	info.Attributes = append(info.Attributes, imap.NoSelectAttr)
+	return info, nil
+}
+
+func TestParseFolders(t *testing.T) {
+	assert.Equal(t, []string{"INBOX"}, ParseFolders(""))
+	assert.Equal(t, []string{"INBOX"}, ParseFolders(" , "))
+	assert.Equal(t, []string{"INBOX", "Replies/*"}, ParseFolders("INBOX, Replies/*,"))
+}
+
+func TestResolveFolders(t *testing.T) {
+	s := server.New(&noselectBackend{Backend: memory.New(), noselect: map[string]bool{"Replies": true}})
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := client.Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer c.Logout()
+	require.NoError(t, c.Login("username", "password"))
+	for _, folder := range []string{"Replies", "Replies/Sales", "Replies/Support", "Archive"} {
+		require.NoError(t, c.Create(folder))
+	}
+
+	for name, test := range map[string]struct {
+		folders  string
+		expected []string
+	}{
+		"plain folders are kept as configured": {folders: "INBOX, Missing", expected: []string{"INBOX", "Missing"}},
+		"wildcards are expanded and sorted":    {folders: "Replies/*", expected: []string{"Replies/Sales", "Replies/Support"}},
+		"unselectable folders are left out":    {folders: "Replies*", expected: []string{"Replies/Sales", "Replies/Support"}},
+		"duplicates are removed":               {folders: "Replies/Support, Replies/%, INBOX, INBOX", expected: []string{"Replies/Support", "Replies/Sales", "INBOX"}},
+		"wildcards matching nothing":           {folders: "Sent/*", expected: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Poller{folders: ParseFolders(test.folders)}
+
+			folders, err := p.resolveFolders(c)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, folders)
+		})
+	}
+}
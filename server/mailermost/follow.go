@@ -0,0 +1,210 @@
+package mailermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	followersKeyPrefix string = "follow_"
+	followedThreadsKey string = "followed_threads"
+	// MaxThreadFollowers is the number of users that can follow a thread by email.
+	MaxThreadFollowers = 100
+	// followedThreadsRefresh is how long FollowedThreads caches the followed threads.
+	followedThreadsRefresh = 10 * time.Second
+
+	maxFollowWriteAttempts = 5
+)
+
+// ErrTooManyFollowers is returned when a thread already has MaxThreadFollowers followers.
+var ErrTooManyFollowers = errors.Errorf("a thread cannot have more than %d followers", MaxThreadFollowers)
+
+// FollowThread emails the given user about new replies in the thread with the given root post.
+func FollowThread(api plugin.API, rootID, userID string) error {
+	_, err := updateFollowers(api, rootID, func(followers []string) ([]string, error) {
+		for _, follower := range followers {
+			if follower == userID {
+				return followers, nil
+			}
+		}
+		if len(followers) >= MaxThreadFollowers {
+			return nil, ErrTooManyFollowers
+		}
+		return append(followers, userID), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return setThreadFollowed(api, rootID, true)
+}
+
+// UnfollowThread stops emailing the given user about new replies in the thread with the given
+// root post.
+func UnfollowThread(api plugin.API, rootID, userID string) error {
+	followers, err := updateFollowers(api, rootID, func(followers []string) ([]string, error) {
+		kept := followers[:0]
+		for _, follower := range followers {
+			if follower != userID {
+				kept = append(kept, follower)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil || len(followers) > 0 {
+		return err
+	}
+
+	if err = setThreadFollowed(api, rootID, false); err != nil {
+		return err
+	}
+
+	// Another user may have followed the thread after the last follower left, and found it
+	// still in the followed threads.
+	if followers, err = ThreadFollowers(api, rootID); err != nil || len(followers) == 0 {
+		return err
+	}
+	return setThreadFollowed(api, rootID, true)
+}
+
+// ThreadFollowers returns the IDs of the users following the thread with the given root post.
+func ThreadFollowers(api plugin.API, rootID string) ([]string, error) {
+	value, appErr := api.KVGet(followersKeyPrefix + rootID)
+	if appErr != nil {
+		return nil, errors.Wrapf(appErr, "failed to get followers of thread %s", rootID)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	var followers []string
+	if err := json.Unmarshal(value, &followers); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse followers of thread %s", rootID)
+	}
+
+	return followers, nil
+}
+
+// updateFollowers replaces the followers of a thread with the result of update, and returns
+// them.
+func updateFollowers(api plugin.API, rootID string, update func([]string) ([]string, error)) ([]string, error) {
+	followers, err := updateList(api, followersKeyPrefix+rootID, update)
+	if err == ErrTooManyFollowers {
+		return nil, err
+	}
+
+	return followers, errors.Wrapf(err, "failed to update followers of thread %s", rootID)
+}
+
+// setThreadFollowed adds the thread with the given root post to the followed threads, or removes
+// it.
+func setThreadFollowed(api plugin.API, rootID string, followed bool) error {
+	_, err := updateList(api, followedThreadsKey, func(threads []string) ([]string, error) {
+		kept := threads[:0]
+		for _, thread := range threads {
+			if thread != rootID {
+				kept = append(kept, thread)
+			}
+		}
+		if followed {
+			kept = append(kept, rootID)
+		}
+		return kept, nil
+	})
+
+	return errors.Wrap(err, "failed to update followed threads")
+}
+
+// updateList replaces the list of strings stored in the given key with the result of update,
+// and returns it. Lists may be updated concurrently, so the key is updated with a
+// compare-and-set and retried if another update got in first. An empty list deletes the key.
+func updateList(api plugin.API, key string, update func([]string) ([]string, error)) ([]string, error) {
+	for attempt := 0; attempt < maxFollowWriteAttempts; attempt++ {
+		current, appErr := api.KVGet(key)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		var list []string
+		if current != nil {
+			if err := json.Unmarshal(current, &list); err != nil {
+				return nil, err
+			}
+		}
+
+		list, err := update(list)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []byte
+		if len(list) > 0 {
+			if next, err = json.Marshal(list); err != nil {
+				return nil, err
+			}
+		}
+		if bytes.Equal(next, current) {
+			return list, nil
+		}
+
+		saved, appErr := api.KVSetWithOptions(key, next, model.PluginKVSetOptions{Atomic: true, OldValue: current})
+		if appErr != nil {
+			return nil, appErr
+		}
+		if saved {
+			return list, nil
+		}
+	}
+
+	return nil, errors.Errorf("gave up after %d attempts", maxFollowWriteAttempts)
+}
+
+// FollowedThreads tells which threads have followers. It caches the followed threads for
+// followedThreadsRefresh, so that replies in threads nobody follows need no KV store lookup.
+type FollowedThreads struct {
+	api plugin.API
+
+	lock    sync.Mutex
+	threads map[string]bool
+	fetched time.Time
+}
+
+// NewFollowedThreads creates a new FollowedThreads instance.
+func NewFollowedThreads(api plugin.API) *FollowedThreads {
+	return &FollowedThreads{api: api}
+}
+
+// Followed reports whether the thread with the given root post has followers. Threads followed
+// less than followedThreadsRefresh ago may not be reported yet.
+func (f *FollowedThreads) Followed(rootID string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.threads == nil || time.Since(f.fetched) >= followedThreadsRefresh {
+		value, appErr := f.api.KVGet(followedThreadsKey)
+		if appErr != nil {
+			return false, errors.Wrap(appErr, "failed to get followed threads")
+		}
+
+		var list []string
+		if value != nil {
+			if err := json.Unmarshal(value, &list); err != nil {
+				return false, errors.Wrap(err, "failed to parse followed threads")
+			}
+		}
+
+		f.threads = make(map[string]bool, len(list))
+		for _, thread := range list {
+			f.threads[thread] = true
+		}
+		f.fetched = time.Now()
+	}
+
+	return f.threads[rootID], nil
+}
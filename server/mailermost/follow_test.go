@@ -0,0 +1,134 @@
+package mailermost
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowThread(t *testing.T) {
+	t.Run("adds the follower", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", followersKeyPrefix+"root").Return([]byte(`["other"]`), nil)
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(`["other","user"]`), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["other"]`)}).Return(true, nil)
+		api.On("KVGet", followedThreadsKey).Return([]byte(`["root"]`), nil)
+
+		require.NoError(t, FollowThread(api, "root", "user"))
+	})
+
+	t.Run("adds the thread to the followed threads", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", followersKeyPrefix+"root").Return(nil, nil)
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(`["user"]`), model.PluginKVSetOptions{Atomic: true}).Return(true, nil)
+		api.On("KVGet", followedThreadsKey).Return([]byte(`["other"]`), nil)
+		api.On("KVSetWithOptions", followedThreadsKey, []byte(`["other","root"]`), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["other"]`)}).Return(true, nil)
+
+		require.NoError(t, FollowThread(api, "root", "user"))
+	})
+
+	t.Run("retries after a concurrent update", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", followersKeyPrefix+"root").Return(nil, nil).Once()
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(`["user"]`), model.PluginKVSetOptions{Atomic: true}).Return(false, nil).Once()
+		api.On("KVGet", followersKeyPrefix+"root").Return([]byte(`["other"]`), nil).Once()
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(`["other","user"]`), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["other"]`)}).Return(true, nil).Once()
+		api.On("KVGet", followedThreadsKey).Return([]byte(`["root"]`), nil)
+
+		require.NoError(t, FollowThread(api, "root", "user"))
+	})
+
+	t.Run("limits the followers", func(t *testing.T) {
+		followers := make([]string, MaxThreadFollowers)
+		for i := range followers {
+			followers[i] = model.NewId()
+		}
+		value, err := json.Marshal(followers)
+		require.NoError(t, err)
+
+		api := &plugintest.API{}
+		api.On("KVGet", followersKeyPrefix+"root").Return(value, nil)
+
+		assert.Equal(t, ErrTooManyFollowers, FollowThread(api, "root", "user"))
+	})
+}
+
+func TestUnfollowThread(t *testing.T) {
+	t.Run("keeps a thread with other followers", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", followersKeyPrefix+"root").Return([]byte(`["other","user"]`), nil)
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(`["other"]`), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["other","user"]`)}).Return(true, nil)
+
+		require.NoError(t, UnfollowThread(api, "root", "user"))
+		api.AssertNotCalled(t, "KVGet", followedThreadsKey)
+	})
+
+	t.Run("removes the thread of the last follower", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", followersKeyPrefix+"root").Return([]byte(`["user"]`), nil).Once()
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(nil), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["user"]`)}).Return(true, nil)
+		api.On("KVGet", followedThreadsKey).Return([]byte(`["root","other"]`), nil)
+		api.On("KVSetWithOptions", followedThreadsKey, []byte(`["other"]`), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["root","other"]`)}).Return(true, nil)
+		api.On("KVGet", followersKeyPrefix+"root").Return(nil, nil).Once()
+
+		require.NoError(t, UnfollowThread(api, "root", "user"))
+	})
+
+	t.Run("adds the thread back if followed meanwhile", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("KVGet", followersKeyPrefix+"root").Return([]byte(`["user"]`), nil).Once()
+		api.On("KVSetWithOptions", followersKeyPrefix+"root", []byte(nil), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["user"]`)}).Return(true, nil)
+		api.On("KVGet", followedThreadsKey).Return([]byte(`["root"]`), nil).Once()
+		api.On("KVSetWithOptions", followedThreadsKey, []byte(nil), model.PluginKVSetOptions{Atomic: true, OldValue: []byte(`["root"]`)}).Return(true, nil)
+		api.On("KVGet", followersKeyPrefix+"root").Return([]byte(`["other"]`), nil).Once()
+		api.On("KVGet", followedThreadsKey).Return(nil, nil).Once()
+		api.On("KVSetWithOptions", followedThreadsKey, []byte(`["root"]`), model.PluginKVSetOptions{Atomic: true}).Return(true, nil)
+
+		require.NoError(t, UnfollowThread(api, "root", "user"))
+	})
+}
+
+func TestFollowedThreads(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	threads := NewFollowedThreads(api)
+
+	api.On("KVGet", followedThreadsKey).Return([]byte(`["root"]`), nil).Once()
+	for i := 0; i < 3; i++ {
+		followed, err := threads.Followed("root")
+		require.NoError(t, err)
+		assert.True(t, followed)
+
+		followed, err = threads.Followed("other")
+		require.NoError(t, err)
+		assert.False(t, followed)
+	}
+
+	// The followed threads are fetched again once the cache is stale.
+	threads.fetched = time.Now().Add(-followedThreadsRefresh)
+	api.On("KVGet", followedThreadsKey).Return(nil, nil).Once()
+	followed, err := threads.Followed("root")
+	require.NoError(t, err)
+	assert.False(t, followed)
+
+	threads.fetched = time.Now().Add(-followedThreadsRefresh)
+	api.On("KVGet", followedThreadsKey).Return(nil, model.NewAppError("KVGet", "", nil, "", 500)).Once()
+	_, err = threads.Followed("root")
+	assert.Error(t, err)
+}
@@ -0,0 +1,210 @@
+package mailermost
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// defaultLocale is the locale used for users whose locale has no bundle.
+const defaultLocale = "en"
+
+// The message IDs of the notices, besides the rejection reasons.
+const (
+	msgIntro        = "intro"
+	msgEmailSubject = "email_subject"
+	msgEmailIntro   = "email_intro"
+	msgQuote        = "quote"
+)
+
+// bundles holds the notice templates by locale and message ID. Rejection reasons are their own
+// message IDs. Users are not told about reasons without a template in the default locale, such
+// as emails from senders that are not users.
+//
+// The templates are Go text templates executed with a noticeData.
+var bundles = map[string]map[string]string{
+	"en": {
+		msgIntro:                              "Your email reply **{{.Subject}}** was not posted to Mattermost.",
+		msgEmailSubject:                       "{{.Subject}} - REPLY NOT POSTED",
+		msgEmailIntro:                         "Your reply was not posted to Mattermost.",
+		msgQuote:                              "This is what you wrote:",
+		string(ReasonEmptyText):               "Mattermost found no text in your email. Please write your reply above the quoted notification.",
+		string(ReasonMuted):                   "You muted email replies with `/mailermost mute`. Use `/mailermost unmute` to post your email replies again.",
+		string(ReasonBatchReply):              "You replied to a notification email about several messages, so Mattermost could not tell which one you replied to. Please reply to a notification about a single message, or reply in Mattermost.",
+		string(ReasonNoPostID):                "Mattermost could not find the thread you replied to. It may have been deleted, or your email program may have removed the link to it from the quoted notification.",
+		string(ReasonUnknownPost):             "Mattermost could not find the thread you replied to. It may have been deleted, or your email program may have removed the link to it from the quoted notification.",
+		string(ReasonNotTeamMember):           "You are not a member of the team of the message you replied to.",
+		string(ReasonNotChannelMember):        "You are not a member of the channel of the message you replied to. Please join the channel and reply again.",
+		string(ReasonArchivedChannel):         "The channel of the message you replied to is archived, so no new messages can be posted in it.",
+		string(ReasonReadOnlyChannel):         "The channel of the message you replied to is read-only.",
+		string(ReasonNoPostPermission):        "You do not have permission to post in the channel of the message you replied to.",
+		string(ReasonTeamNotServed):           "Replies to messages in that team are not accepted at this email address.",
+		string(ReasonUnknownChannelAddress):   "The channel address you emailed is no longer valid. Please ask a channel admin for the current address.",
+		string(ReasonUnknownRecipient):        "The direct message address you emailed is no longer valid, or its user is deactivated. Please ask the user for their current address.",
+		string(ReasonDirectMessageRestricted): "You can only send direct messages to members of your teams.",
+		string(ReasonUnverifiedSender):        "Mattermost only starts new posts from emails that pass DMARC verification, and your email did not. Please post in Mattermost instead, or ask your System Admin about your email domain.",
+		string(ReasonTooManyFollowers):        "Too many users already follow that thread by email.",
+		string(ReasonTooLarge):                "Your email is {{.Size}}, which is more than the {{.MaxSize}} that Mattermost accepts. Please reply again with a shorter message or without attachments.",
+		string(ReasonInternalError):           "Something went wrong while processing your email. Please reply in Mattermost, or contact your System Admin.",
+	},
+	"de": {
+		msgIntro:                              "Deine E-Mail-Antwort **{{.Subject}}** wurde nicht in Mattermost veröffentlicht.",
+		msgEmailSubject:                       "{{.Subject}} - ANTWORT NICHT VERÖFFENTLICHT",
+		msgEmailIntro:                         "Deine Antwort wurde nicht in Mattermost veröffentlicht.",
+		msgQuote:                              "Das hast du geschrieben:",
+		string(ReasonEmptyText):               "Mattermost hat in deiner E-Mail keinen Text gefunden. Bitte schreibe deine Antwort über die zitierte Benachrichtigung.",
+		string(ReasonMuted):                   "Du hast E-Mail-Antworten mit `/mailermost mute` stummgeschaltet. Verwende `/mailermost unmute`, um deine E-Mail-Antworten wieder zu veröffentlichen.",
+		string(ReasonBatchReply):              "Du hast auf eine Benachrichtigung zu mehreren Nachrichten geantwortet, daher konnte Mattermost nicht erkennen, auf welche du antworten wolltest. Bitte antworte auf eine Benachrichtigung zu einer einzelnen Nachricht oder direkt in Mattermost.",
+		string(ReasonNoPostID):                "Mattermost konnte den Thread, auf den du geantwortet hast, nicht finden. Er wurde möglicherweise gelöscht, oder dein E-Mail-Programm hat den Link darauf aus der zitierten Benachrichtigung entfernt.",
+		string(ReasonUnknownPost):             "Mattermost konnte den Thread, auf den du geantwortet hast, nicht finden. Er wurde möglicherweise gelöscht, oder dein E-Mail-Programm hat den Link darauf aus der zitierten Benachrichtigung entfernt.",
+		string(ReasonNotTeamMember):           "Du bist kein Mitglied des Teams der Nachricht, auf die du geantwortet hast.",
+		string(ReasonNotChannelMember):        "Du bist kein Mitglied des Kanals der Nachricht, auf die du geantwortet hast. Bitte tritt dem Kanal bei und antworte erneut.",
+		string(ReasonArchivedChannel):         "Der Kanal der Nachricht, auf die du geantwortet hast, ist archiviert, daher können dort keine neuen Nachrichten veröffentlicht werden.",
+		string(ReasonReadOnlyChannel):         "Der Kanal der Nachricht, auf die du geantwortet hast, ist schreibgeschützt.",
+		string(ReasonNoPostPermission):        "Du bist nicht berechtigt, im Kanal der Nachricht, auf die du geantwortet hast, zu schreiben.",
+		string(ReasonTeamNotServed):           "Antworten auf Nachrichten in diesem Team werden unter dieser E-Mail-Adresse nicht angenommen.",
+		string(ReasonUnknownChannelAddress):   "Die Kanal-Adresse, an die du geschrieben hast, ist nicht mehr gültig. Bitte frage einen Kanal-Administrator nach der aktuellen Adresse.",
+		string(ReasonUnknownRecipient):        "Die Direktnachrichten-Adresse, an die du geschrieben hast, ist nicht mehr gültig oder ihr Benutzer ist deaktiviert. Bitte frage den Benutzer nach seiner aktuellen Adresse.",
+		string(ReasonDirectMessageRestricted): "Du kannst nur Mitgliedern deiner Teams Direktnachrichten senden.",
+		string(ReasonUnverifiedSender):        "Mattermost beginnt neue Beiträge nur aus E-Mails, die die DMARC-Prüfung bestehen, und deine E-Mail hat sie nicht bestanden. Bitte schreibe stattdessen in Mattermost oder frage deinen Systemadministrator nach deiner E-Mail-Domain.",
+		string(ReasonTooManyFollowers):        "Diesem Thread folgen bereits zu viele Benutzer per E-Mail.",
+		string(ReasonTooLarge):                "Deine E-Mail ist {{.Size}} groß und damit größer als die {{.MaxSize}}, die Mattermost annimmt. Bitte antworte erneut mit einer kürzeren Nachricht oder ohne Anhänge.",
+		string(ReasonInternalError):           "Bei der Verarbeitung deiner E-Mail ist ein Fehler aufgetreten. Bitte antworte direkt in Mattermost oder wende dich an deinen Systemadministrator.",
+	},
+	"es": {
+		msgIntro:                              "Tu respuesta por correo electrónico **{{.Subject}}** no se publicó en Mattermost.",
+		msgEmailSubject:                       "{{.Subject}} - RESPUESTA NO PUBLICADA",
+		msgEmailIntro:                         "Tu respuesta no se publicó en Mattermost.",
+		msgQuote:                              "Esto es lo que escribiste:",
+		string(ReasonEmptyText):               "Mattermost no encontró texto en tu correo. Escribe tu respuesta encima de la notificación citada.",
+		string(ReasonMuted):                   "Silenciaste las respuestas por correo con `/mailermost mute`. Usa `/mailermost unmute` para volver a publicar tus respuestas por correo.",
+		string(ReasonBatchReply):              "Respondiste a una notificación sobre varios mensajes, por lo que Mattermost no pudo saber a cuál respondías. Responde a una notificación sobre un único mensaje o responde en Mattermost.",
+		string(ReasonNoPostID):                "Mattermost no encontró el hilo al que respondiste. Puede que se haya eliminado o que tu programa de correo haya quitado el enlace de la notificación citada.",
+		string(ReasonUnknownPost):             "Mattermost no encontró el hilo al que respondiste. Puede que se haya eliminado o que tu programa de correo haya quitado el enlace de la notificación citada.",
+		string(ReasonNotTeamMember):           "No eres miembro del equipo del mensaje al que respondiste.",
+		string(ReasonNotChannelMember):        "No eres miembro del canal del mensaje al que respondiste. Únete al canal y vuelve a responder.",
+		string(ReasonArchivedChannel):         "El canal del mensaje al que respondiste está archivado, por lo que no se pueden publicar mensajes nuevos en él.",
+		string(ReasonReadOnlyChannel):         "El canal del mensaje al que respondiste es de solo lectura.",
+		string(ReasonNoPostPermission):        "No tienes permiso para publicar en el canal del mensaje al que respondiste.",
+		string(ReasonTeamNotServed):           "Las respuestas a mensajes de ese equipo no se aceptan en esta dirección de correo.",
+		string(ReasonUnknownChannelAddress):   "La dirección de canal a la que escribiste ya no es válida. Pide la dirección actual a un administrador del canal.",
+		string(ReasonUnknownRecipient):        "La dirección de mensajes directos a la que escribiste ya no es válida o su usuario está desactivado. Pide al usuario su dirección actual.",
+		string(ReasonDirectMessageRestricted): "Solo puedes enviar mensajes directos a miembros de tus equipos.",
+		string(ReasonUnverifiedSender):        "Mattermost solo inicia publicaciones nuevas a partir de correos que superan la verificación DMARC, y tu correo no la superó. Publica en Mattermost o consulta a tu administrador del sistema sobre tu dominio de correo.",
+		string(ReasonTooManyFollowers):        "Demasiados usuarios ya siguen ese hilo por correo.",
+		string(ReasonTooLarge):                "Tu correo ocupa {{.Size}}, más de los {{.MaxSize}} que acepta Mattermost. Vuelve a responder con un mensaje más corto o sin adjuntos.",
+		string(ReasonInternalError):           "Se produjo un error al procesar tu correo. Responde directamente en Mattermost o contacta con tu administrador del sistema.",
+	},
+	"fr": {
+		msgIntro:                              "Votre réponse par e-mail **{{.Subject}}** n'a pas été publiée dans Mattermost.",
+		msgEmailSubject:                       "{{.Subject}} - RÉPONSE NON PUBLIÉE",
+		msgEmailIntro:                         "Votre réponse n'a pas été publiée dans Mattermost.",
+		msgQuote:                              "Voici ce que vous avez écrit :",
+		string(ReasonEmptyText):               "Mattermost n'a trouvé aucun texte dans votre e-mail. Veuillez écrire votre réponse au-dessus de la notification citée.",
+		string(ReasonMuted):                   "Vous avez désactivé les réponses par e-mail avec `/mailermost mute`. Utilisez `/mailermost unmute` pour publier à nouveau vos réponses par e-mail.",
+		string(ReasonBatchReply):              "Vous avez répondu à une notification portant sur plusieurs messages, Mattermost n'a donc pas pu déterminer auquel vous répondiez. Veuillez répondre à une notification portant sur un seul message, ou répondre dans Mattermost.",
+		string(ReasonNoPostID):                "Mattermost n'a pas trouvé le fil auquel vous avez répondu. Il a peut-être été supprimé, ou votre logiciel de messagerie a retiré le lien vers celui-ci de la notification citée.",
+		string(ReasonUnknownPost):             "Mattermost n'a pas trouvé le fil auquel vous avez répondu. Il a peut-être été supprimé, ou votre logiciel de messagerie a retiré le lien vers celui-ci de la notification citée.",
+		string(ReasonNotTeamMember):           "Vous n'êtes pas membre de l'équipe du message auquel vous avez répondu.",
+		string(ReasonNotChannelMember):        "Vous n'êtes pas membre du canal du message auquel vous avez répondu. Veuillez rejoindre le canal et répondre à nouveau.",
+		string(ReasonArchivedChannel):         "Le canal du message auquel vous avez répondu est archivé : aucun nouveau message ne peut y être publié.",
+		string(ReasonReadOnlyChannel):         "Le canal du message auquel vous avez répondu est en lecture seule.",
+		string(ReasonNoPostPermission):        "Vous n'avez pas l'autorisation de publier dans le canal du message auquel vous avez répondu.",
+		string(ReasonTeamNotServed):           "Les réponses aux messages de cette équipe ne sont pas acceptées à cette adresse e-mail.",
+		string(ReasonUnknownChannelAddress):   "L'adresse de canal à laquelle vous avez écrit n'est plus valide. Veuillez demander l'adresse actuelle à un administrateur du canal.",
+		string(ReasonUnknownRecipient):        "L'adresse de messages directs à laquelle vous avez écrit n'est plus valide, ou son utilisateur est désactivé. Veuillez demander son adresse actuelle à l'utilisateur.",
+		string(ReasonDirectMessageRestricted): "Vous ne pouvez envoyer des messages directs qu'aux membres de vos équipes.",
+		string(ReasonUnverifiedSender):        "Mattermost ne crée de nouveaux messages qu'à partir d'e-mails qui réussissent la vérification DMARC, ce qui n'est pas le cas du vôtre. Veuillez publier dans Mattermost, ou interrogez votre administrateur système au sujet de votre domaine de messagerie.",
+		string(ReasonTooManyFollowers):        "Trop d'utilisateurs suivent déjà ce fil par e-mail.",
+		string(ReasonTooLarge):                "Votre e-mail fait {{.Size}}, soit plus que les {{.MaxSize}} acceptés par Mattermost. Veuillez répondre à nouveau avec un message plus court ou sans pièces jointes.",
+		string(ReasonInternalError):           "Une erreur est survenue lors du traitement de votre e-mail. Veuillez répondre directement dans Mattermost ou contacter votre administrateur système.",
+	},
+}
+
+// noticeData is the data the notice templates are executed with.
+type noticeData struct {
+	// Username is the username of the user told.
+	Username string
+	// Subject is the subject of the email.
+	Subject string
+	// Size is the formatted size of the email, for ReasonTooLarge.
+	Size string
+	// MaxSize is the formatted maximum email size, for ReasonTooLarge.
+	MaxSize string
+}
+
+// noticeTemplates holds the parsed notice templates by locale and message ID.
+type noticeTemplates map[string]map[string]*template.Template
+
+// newNoticeTemplates parses the bundled notice templates, replacing those given in overrides,
+// which maps locales to message IDs to templates.
+func newNoticeTemplates(overrides map[string]map[string]string) (noticeTemplates, error) {
+	templates := make(noticeTemplates)
+	for _, source := range []map[string]map[string]string{bundles, overrides} {
+		for locale, messages := range source {
+			locale = normalizeLocale(locale)
+			if templates[locale] == nil {
+				templates[locale] = make(map[string]*template.Template)
+			}
+
+			for id, text := range messages {
+				tmpl, err := template.New(id).Parse(text)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid notice template %q for locale %q", id, locale)
+				}
+				templates[locale][id] = tmpl
+			}
+		}
+	}
+
+	return templates, nil
+}
+
+// ValidateNoticeTemplates checks that the given notice template overrides, which map locales to
+// message IDs to templates, parse.
+func ValidateNoticeTemplates(overrides map[string]map[string]string) error {
+	_, err := newNoticeTemplates(overrides)
+	return err
+}
+
+// lookup returns the template with the given message ID for the locale, falling back to the
+// locale's language, e.g. pt for pt-BR, and then to the default locale. It returns nil if there
+// is no such template.
+func (t noticeTemplates) lookup(locale, id string) *template.Template {
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if i := strings.Index(locale, "-"); i > 0 {
+		candidates = append(candidates, locale[:i])
+	}
+	candidates = append(candidates, defaultLocale)
+
+	for _, candidate := range candidates {
+		if tmpl := t[candidate][id]; tmpl != nil {
+			return tmpl
+		}
+	}
+
+	return nil
+}
+
+// normalizeLocale lower-cases a locale and separates its language and region with a hyphen.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// render executes the template with the given message ID for the locale. It returns false if
+// there is no such template.
+func (t noticeTemplates) render(locale, id string, data noticeData) (string, bool, error) {
+	tmpl := t.lookup(locale, id)
+	if tmpl == nil {
+		return "", false, nil
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", false, errors.Wrapf(err, "failed to render notice template %q", id)
+	}
+
+	return sb.String(), true, nil
+}
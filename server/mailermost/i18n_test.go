@@ -0,0 +1,55 @@
+package mailermost
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoticeTemplates(t *testing.T) {
+	t.Run("every locale covers the messages of the default locale", func(t *testing.T) {
+		for locale, messages := range bundles {
+			for id := range bundles[defaultLocale] {
+				assert.Contains(t, messages, id, "locale %s", locale)
+			}
+		}
+	})
+
+	t.Run("fall back to language and default locale", func(t *testing.T) {
+		notices, err := newNoticeTemplates(map[string]map[string]string{
+			"pt_BR": {msgQuote: "Você escreveu:"},
+		})
+		require.NoError(t, err)
+
+		for locale, expected := range map[string]string{
+			"pt-BR": "Você escreveu:",
+			"fr":    "Voici ce que vous avez écrit :",
+			"fr-CA": "Voici ce que vous avez écrit :",
+			"ja":    "This is what you wrote:",
+			"":      "This is what you wrote:",
+		} {
+			text, ok, err := notices.render(locale, msgQuote, noticeData{})
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, expected, text, "locale %q", locale)
+		}
+	})
+
+	t.Run("override with template data", func(t *testing.T) {
+		notices, err := newNoticeTemplates(map[string]map[string]string{
+			"en": {string(ReasonTooLarge): "{{.Username}}, {{.Size}} is over {{.MaxSize}}."},
+		})
+		require.NoError(t, err)
+
+		text, ok, err := notices.render("en", string(ReasonTooLarge), noticeData{Username: "alice", Size: "12 MB", MaxSize: "10 MB"})
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "alice, 12 MB is over 10 MB.", text)
+	})
+
+	t.Run("reject invalid templates", func(t *testing.T) {
+		err := ValidateNoticeTemplates(map[string]map[string]string{"en": {msgIntro: "{{.Subject"}})
+		assert.Error(t, err)
+	})
+}
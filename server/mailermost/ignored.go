@@ -0,0 +1,52 @@
+package mailermost
+
+import (
+	"sync"
+
+	imap "github.com/emersion/go-imap"
+)
+
+// ignoredEmails remembers the UIDs of the emails in each folder that are not addressed to the
+// account, so that they are left out of the search on later polls instead of being fetched
+// again. A folder is forgotten when its UIDVALIDITY changes, as its UIDs are then reassigned.
+type ignoredEmails struct {
+	lock    sync.Mutex
+	folders map[string]*ignoredFolder
+}
+
+type ignoredFolder struct {
+	uidValidity uint32
+	uids        *imap.SeqSet
+}
+
+func newIgnoredEmails() *ignoredEmails {
+	return &ignoredEmails{folders: make(map[string]*ignoredFolder)}
+}
+
+// uids returns the UIDs of the ignored emails in the folder, or nil if there are none.
+func (i *ignoredEmails) uids(folder string, uidValidity uint32) *imap.SeqSet {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	f, ok := i.folders[folder]
+	if !ok || f.uidValidity != uidValidity || f.uids.Empty() {
+		return nil
+	}
+
+	uids := new(imap.SeqSet)
+	uids.AddSet(f.uids)
+	return uids
+}
+
+// add remembers that the email with the given UID in the folder is ignored.
+func (i *ignoredEmails) add(folder string, uidValidity uint32, uid uint32) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	f, ok := i.folders[folder]
+	if !ok || f.uidValidity != uidValidity {
+		f = &ignoredFolder{uidValidity: uidValidity, uids: new(imap.SeqSet)}
+		i.folders[folder] = f
+	}
+	f.uids.AddNum(uid)
+}
@@ -0,0 +1,50 @@
+package mailermost
+
+import (
+	"strings"
+	"testing"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoredEmails(t *testing.T) {
+	ignored := newIgnoredEmails()
+	assert.Nil(t, ignored.uids("INBOX", 1))
+
+	ignored.add("INBOX", 1, 3)
+	ignored.add("INBOX", 1, 4)
+	ignored.add("INBOX", 1, 7)
+	ignored.add("Archive", 1, 9)
+	assert.Equal(t, "3:4,7", ignored.uids("INBOX", 1).String())
+	assert.Equal(t, "9", ignored.uids("Archive", 1).String())
+
+	// The UIDs of a folder are reassigned when its UIDVALIDITY changes.
+	assert.Nil(t, ignored.uids("INBOX", 2))
+	ignored.add("INBOX", 2, 5)
+	assert.Equal(t, "5", ignored.uids("INBOX", 2).String())
+
+	// The set returned is a copy.
+	ignored.uids("INBOX", 2).AddNum(6)
+	assert.Equal(t, "5", ignored.uids("INBOX", 2).String())
+}
+
+func TestAddressedToAccount(t *testing.T) {
+	p := &Poller{account: Account{Email: "replies@example.org"}}
+
+	// Servers answer without the PEEK of the section fetched.
+	section := &imap.BodySectionName{BodyPartName: recipientSection.BodyPartName}
+	message := func(header string) *imap.Message {
+		return &imap.Message{Body: map[*imap.BodySectionName]imap.Literal{
+			section: strings.NewReader(header),
+		}}
+	}
+
+	assert.True(t, p.addressedToAccount(message("To: Mattermost <replies@example.org>\r\n\r\n")))
+	assert.True(t, p.addressedToAccount(message("Delivered-To: replies+ch-abc123@example.org\r\nTo: list@example.org\r\n\r\n")))
+	assert.False(t, p.addressedToAccount(message("To: someone@example.org\r\n\r\n")))
+	assert.False(t, p.addressedToAccount(message("\r\n")))
+
+	// Emails whose recipients could not be fetched are checked again once fetched in full.
+	assert.True(t, p.addressedToAccount(&imap.Message{}))
+}
@@ -0,0 +1,131 @@
+package mailermost
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	pollLockKey    string = "poll_lock"
+	minPollLockTTL        = 30 * time.Second
+)
+
+// pollLock is a cluster-wide lock kept in the plugin KV store. Only the node holding it polls
+// the mailbox. The holder renews it while polling, and any other node takes it over once it
+// has expired, e.g. because the holding node died.
+type pollLock struct {
+	api    plugin.API
+	key    string
+	nodeID string
+	ttl    time.Duration
+}
+
+type pollLockValue struct {
+	NodeID   string `json:"node_id"`
+	ExpireAt int64  `json:"expire_at"`
+}
+
+func newPollLock(api plugin.API, key string, ttl time.Duration) *pollLock {
+	if ttl < minPollLockTTL {
+		ttl = minPollLockTTL
+	}
+
+	return &pollLock{
+		api:    api,
+		key:    key,
+		nodeID: model.NewId(),
+		ttl:    ttl,
+	}
+}
+
+// tryAcquire takes the lock if it is free, expired or already held by this node, extending
+// its expiry in every case. It reports whether this node holds the lock afterwards.
+func (l *pollLock) tryAcquire() (bool, error) {
+	current, appErr := l.api.KVGet(l.key)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to get lock %q", l.key)
+	}
+
+	if current != nil {
+		var value pollLockValue
+		if err := json.Unmarshal(current, &value); err != nil {
+			return false, errors.Wrapf(err, "failed to decode lock %q", l.key)
+		}
+
+		if value.NodeID != l.nodeID && value.ExpireAt > model.GetMillis() {
+			return false, nil
+		}
+	}
+
+	next, err := json.Marshal(pollLockValue{
+		NodeID:   l.nodeID,
+		ExpireAt: model.GetMillis() + l.ttl.Milliseconds(),
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to encode lock")
+	}
+
+	// Expiry is decided by the ExpireAt stored in the value rather than by the KV store, so
+	// that an expired lock can still be compared and swapped.
+	acquired, appErr := l.api.KVSetWithOptions(l.key, next, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: current,
+	})
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to set lock %q", l.key)
+	}
+
+	return acquired, nil
+}
+
+// heartbeat renews the lock until ctx is done. If the lock is lost, lost is called and
+// heartbeat returns.
+func (l *pollLock) heartbeat(ctx context.Context, lost func()) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			acquired, err := l.tryAcquire()
+			if err != nil {
+				l.api.LogError("Failed to renew poll lock", "error", err.Error())
+				continue
+			}
+			if !acquired {
+				l.api.LogWarn("Lost poll lock to another node")
+				lost()
+				return
+			}
+		}
+	}
+}
+
+// release gives up the lock if this node holds it, so another node can take over without
+// waiting for it to expire.
+func (l *pollLock) release() {
+	current, appErr := l.api.KVGet(l.key)
+	if appErr != nil {
+		l.api.LogError("Failed to get poll lock", "error", appErr.Error())
+		return
+	}
+	if current == nil {
+		return
+	}
+
+	var value pollLockValue
+	if err := json.Unmarshal(current, &value); err != nil || value.NodeID != l.nodeID {
+		return
+	}
+
+	if _, appErr = l.api.KVCompareAndDelete(l.key, current); appErr != nil {
+		l.api.LogError("Failed to release poll lock", "error", appErr.Error())
+	}
+}
@@ -0,0 +1,91 @@
+package mailermost
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollLock(t *testing.T) {
+	encode := func(t *testing.T, value pollLockValue) []byte {
+		b, err := json.Marshal(value)
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("free lock", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		lock := newPollLock(api, pollLockKey, time.Minute)
+		api.On("KVGet", pollLockKey).Return(nil, nil)
+		api.On("KVSetWithOptions", pollLockKey, mock.Anything, mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+			return options.Atomic && options.OldValue == nil && options.ExpireInSeconds == 0
+		})).Return(true, nil)
+
+		acquired, err := lock.tryAcquire()
+		require.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("held by another node", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		lock := newPollLock(api, pollLockKey, time.Minute)
+		current := encode(t, pollLockValue{NodeID: model.NewId(), ExpireAt: model.GetMillis() + 60000})
+		api.On("KVGet", pollLockKey).Return(current, nil)
+
+		acquired, err := lock.tryAcquire()
+		require.NoError(t, err)
+		assert.False(t, acquired)
+	})
+
+	t.Run("expired lock of another node", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		lock := newPollLock(api, pollLockKey, time.Minute)
+		current := encode(t, pollLockValue{NodeID: model.NewId(), ExpireAt: model.GetMillis() - 1})
+		api.On("KVGet", pollLockKey).Return(current, nil)
+		api.On("KVSetWithOptions", pollLockKey, mock.Anything, mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+			return options.Atomic && string(options.OldValue) == string(current)
+		})).Return(true, nil)
+
+		acquired, err := lock.tryAcquire()
+		require.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("lost race", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		lock := newPollLock(api, pollLockKey, time.Minute)
+		api.On("KVGet", pollLockKey).Return(nil, nil)
+		api.On("KVSetWithOptions", pollLockKey, mock.Anything, mock.Anything).Return(false, nil)
+
+		acquired, err := lock.tryAcquire()
+		require.NoError(t, err)
+		assert.False(t, acquired)
+		api.AssertNotCalled(t, "KVDelete", mock.Anything)
+	})
+
+	t.Run("release only own lock", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		lock := newPollLock(api, pollLockKey, time.Minute)
+		current := encode(t, pollLockValue{NodeID: model.NewId(), ExpireAt: model.GetMillis() + 60000})
+		api.On("KVGet", pollLockKey).Return(current, nil)
+
+		lock.release()
+		api.AssertNotCalled(t, "KVCompareAndDelete", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,187 @@
+package mailermost
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// The ways of posting replies longer than the server accepts in a post.
+const (
+	// LongReplySplit splits the reply into numbered posts in its thread.
+	LongReplySplit = "split"
+	// LongReplyAttachment uploads the reply as a text file, posted with a preview of it.
+	LongReplyAttachment = "attachment"
+)
+
+const (
+	// maxReplyParts is the number of posts a reply is split into at most. Longer replies are
+	// uploaded as a text file.
+	maxReplyParts = 10
+	// maxPartLabelLength is the length of the widest label numbering the parts of a reply.
+	maxPartLabelLength = len("\n\n(10/10)")
+
+	longReplyPreviewLength = 500
+	longReplyFileName      = "message.txt"
+
+	// messageTooLongErrorID is the ID of the error the server returns for posts longer than it
+	// accepts.
+	messageTooLongErrorID = "model.post.is_valid.msg.app_error"
+)
+
+// ValidateLongReplyMode checks the way of posting long replies. An empty mode means
+// LongReplySplit.
+func ValidateLongReplyMode(mode string) error {
+	switch mode {
+	case "", LongReplySplit, LongReplyAttachment:
+		return nil
+	default:
+		return errors.Errorf("unknown long reply mode %q, expected %s or %s", mode, LongReplySplit, LongReplyAttachment)
+	}
+}
+
+// createPosts creates the post. If its message is longer than the server accepts, it is split
+// into numbered posts in the thread of the post, or uploaded as a text file and posted with a
+// preview, as configured. It returns the first post created. Failing to create the rest of the
+// posts of a split message is only logged, so that the first ones are not posted again.
+//
+// If the server rejects a post as too long, its database schema has the smaller limit of older
+// versions, which is used from then on.
+func (p *Poller) createPosts(post *model.Post) (*model.Post, error) {
+	size := p.maxPostSize()
+	created, err := p.createSizedPosts(post, size)
+	if appErr, ok := err.(*model.AppError); ok && appErr.Id == messageTooLongErrorID && size > model.POST_MESSAGE_MAX_RUNES_V1 {
+		p.api.LogWarn(fmt.Sprintf("the server rejected a post of at most %d characters, using the limit of %d characters of older database schemas", size, model.POST_MESSAGE_MAX_RUNES_V1))
+		atomic.StoreInt32(&p.oldPostSchema, 1)
+		return p.createSizedPosts(post, model.POST_MESSAGE_MAX_RUNES_V1)
+	}
+
+	return created, err
+}
+
+// maxPostSize returns the number of characters the server accepts in a post, as configured, or
+// the limit of older database schemas once the server rejected a longer post.
+func (p *Poller) maxPostSize() int {
+	size := p.settings.MaxPostSize
+	if size <= 0 {
+		size = model.POST_MESSAGE_MAX_RUNES_V2
+	}
+	if atomic.LoadInt32(&p.oldPostSchema) == 1 && size > model.POST_MESSAGE_MAX_RUNES_V1 {
+		size = model.POST_MESSAGE_MAX_RUNES_V1
+	}
+
+	return size
+}
+
+// createSizedPosts creates the post, splitting its message or uploading it as a text file if it
+// is longer than size characters.
+func (p *Poller) createSizedPosts(post *model.Post, size int) (*model.Post, error) {
+	if utf8.RuneCountInString(post.Message) <= size {
+		created, appErr := p.api.CreatePost(post)
+		if appErr != nil {
+			return nil, appErr
+		}
+		return created, nil
+	}
+
+	parts := splitMessage(post.Message, size-maxPartLabelLength)
+	if (p.settings.LongReplyMode == LongReplyAttachment || len(parts) > maxReplyParts) && len(post.FileIds) < maxAttachmentsPerPost {
+		return p.createPostWithTextFile(post)
+	}
+
+	first := post.Clone()
+	first.Message = parts[0] + partLabel(1, len(parts))
+	created, appErr := p.api.CreatePost(first)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = created.Id
+	}
+	for i, part := range parts[1:] {
+		if _, appErr = p.api.CreatePost(&model.Post{
+			UserId:    post.UserId,
+			ChannelId: post.ChannelId,
+			RootId:    rootID,
+			ParentId:  rootID,
+			Message:   part + partLabel(i+2, len(parts)),
+			Props:     post.Props,
+		}); appErr != nil {
+			p.api.LogError(fmt.Sprintf("failed to create part %d of %d of post %s: %s", i+2, len(parts), created.Id, appErr.Error()))
+			break
+		}
+	}
+
+	return created, nil
+}
+
+// createPostWithTextFile uploads the message of the post as a text file and creates the post
+// with a preview of the message instead.
+func (p *Poller) createPostWithTextFile(post *model.Post) (*model.Post, error) {
+	info, appErr := p.api.UploadFile([]byte(post.Message), post.ChannelId, longReplyFileName)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	preview, _ := truncate(post.Message, longReplyPreviewLength)
+
+	withFile := post.Clone()
+	withFile.Message = preview + "...\n\n_The full message is attached._"
+	withFile.FileIds = append(append(model.StringArray{}, post.FileIds...), info.Id)
+
+	created, appErr := p.api.CreatePost(withFile)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	return created, nil
+}
+
+// partLabel numbers a part of a split message.
+func partLabel(part, parts int) string {
+	if parts == 1 {
+		return ""
+	}
+
+	return fmt.Sprintf("\n\n(%d/%d)", part, parts)
+}
+
+// splitMessage splits text into parts of at most size characters. It cuts at the end of a
+// paragraph, a line or a word in the second half of a part if there is one.
+func splitMessage(text string, size int) []string {
+	var parts []string
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > size {
+		cut := cutPoint(runes[:size+1])
+		parts = append(parts, strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace))
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+
+	return append(parts, string(runes))
+}
+
+// cutPoint returns where to cut runes so that at most all but the last rune are kept,
+// preferring the end of a paragraph, then of a line, then of a word.
+func cutPoint(runes []rune) int {
+	limit := len(runes) - 1
+	for _, isBoundary := range []func(i int) bool{
+		func(i int) bool { return runes[i] == '\n' && runes[i-1] == '\n' },
+		func(i int) bool { return runes[i] == '\n' },
+		func(i int) bool { return unicode.IsSpace(runes[i]) },
+	} {
+		for i := limit; i > limit/2; i-- {
+			if isBoundary(i) {
+				return i
+			}
+		}
+	}
+
+	return limit
+}
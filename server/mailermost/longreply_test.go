@@ -0,0 +1,112 @@
+package mailermost
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMessage(t *testing.T) {
+	for name, test := range map[string]struct {
+		text     string
+		size     int
+		expected []string
+	}{
+		"short":      {text: "Lunch at noon?", size: 20, expected: []string{"Lunch at noon?"}},
+		"paragraphs": {text: "First point.\n\nSecond point, longer.", size: 25, expected: []string{"First point.", "Second point, longer."}},
+		"lines":      {text: "A first line\nthe second", size: 15, expected: []string{"A first line", "the second"}},
+		"words":      {text: "Grüße aus Köln und Düsseldorf", size: 15, expected: []string{"Grüße aus Köln", "und Düsseldorf"}},
+		"no spaces":  {text: "日本語のテキストです", size: 4, expected: []string{"日本語の", "テキスト", "です"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, splitMessage(test.text, test.size))
+		})
+	}
+}
+
+func TestCreatePosts(t *testing.T) {
+	newPoller := func(api *plugintest.API, mode string) *Poller {
+		return &Poller{api: api, settings: Settings{LongReplyMode: mode, MaxPostSize: 32}}
+	}
+	message := "First paragraph here.\n\nSecond paragraph here."
+
+	t.Run("short message", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		post := &model.Post{Message: "Lunch?"}
+		api.On("CreatePost", post).Return(&model.Post{Id: "post"}, nil)
+
+		created, err := newPoller(api, LongReplySplit).createPosts(post)
+		require.NoError(t, err)
+		assert.Equal(t, "post", created.Id)
+	})
+
+	t.Run("split", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "First paragraph here.\n\n(1/2)" && post.RootId == "root"
+		})).Return(&model.Post{Id: "first"}, nil).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "Second paragraph here.\n\n(2/2)" && post.RootId == "root" && post.Props[PropSource] == SourceEmail
+		})).Return(&model.Post{Id: "second"}, nil).Once()
+
+		created, err := newPoller(api, LongReplySplit).createPosts(&model.Post{
+			RootId:   "root",
+			ParentId: "root",
+			Message:  message,
+			Props:    model.StringInterface{PropSource: SourceEmail},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "first", created.Id)
+	})
+
+	t.Run("split new post", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool { return post.RootId == "" })).Return(&model.Post{Id: "first"}, nil).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool { return post.RootId == "first" })).Return(&model.Post{Id: "second"}, nil).Once()
+
+		_, err := newPoller(api, LongReplySplit).createPosts(&model.Post{Message: message})
+		require.NoError(t, err)
+	})
+
+	t.Run("attachment", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("UploadFile", []byte(message), "channel", longReplyFileName).Return(&model.FileInfo{Id: "file"}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return strings.HasPrefix(post.Message, message+"...") && len(post.FileIds) == 2 && post.FileIds[1] == "file"
+		})).Return(&model.Post{Id: "post"}, nil)
+
+		_, err := newPoller(api, LongReplyAttachment).createPosts(&model.Post{ChannelId: "channel", Message: message, FileIds: []string{"image"}})
+		require.NoError(t, err)
+	})
+
+	t.Run("older database schema", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		long := strings.Repeat("word ", model.POST_MESSAGE_MAX_RUNES_V1/5+10)
+		api.On("LogWarn", mock.Anything).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool { return post.Message == long })).
+			Return(nil, model.NewAppError("Post.IsValid", messageTooLongErrorID, nil, "", http.StatusBadRequest)).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return utf8.RuneCountInString(post.Message) <= model.POST_MESSAGE_MAX_RUNES_V1 && strings.HasSuffix(post.Message, "(1/2)")
+		})).Return(&model.Post{Id: "first"}, nil).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool { return strings.HasSuffix(post.Message, "(2/2)") })).Return(&model.Post{Id: "second"}, nil).Once()
+
+		p := &Poller{api: api}
+		assert.Equal(t, model.POST_MESSAGE_MAX_RUNES_V2, p.maxPostSize())
+		created, err := p.createPosts(&model.Post{Message: long})
+		require.NoError(t, err)
+		assert.Equal(t, "first", created.Id)
+		assert.Equal(t, model.POST_MESSAGE_MAX_RUNES_V1, p.maxPostSize())
+	})
+}
@@ -1,12 +1,12 @@
 package mailermost
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/mail"
+	"net"
 	"regexp"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	imap "github.com/emersion/go-imap"
@@ -21,51 +21,221 @@ import (
 const (
 	postIDUrlRe                    string = `https?:\/\/.*\/pl\/[a-z0-9]{26}`
 	emailLineEndingRe              string = `=\r\n`
-	mailboxName                    string = "INBOX"
-	ellipsisLen                    int    = 50
 	maxEmailsPerInterval                  = 1000
 	maxPostIDsPerNotificationEmail        = 2
 )
 
-// Poller holds the server configuration values required to poll the IMAP mailbox.
+var (
+	// imapDialTimeout bounds connecting to the IMAP server, including the TLS handshake and
+	// the greeting.
+	imapDialTimeout = 30 * time.Second
+	// imapCommandTimeout bounds each IMAP command. It allows fetching emails of the maximum
+	// size over slow connections.
+	imapCommandTimeout = 5 * time.Minute
+)
+
+// Settings holds the plugin-wide settings shared by the pollers of all accounts.
+type Settings struct {
+	// PollingInterval is the time between two polls in seconds.
+	PollingInterval int
+	// MaxMessageSize is the size in bytes above which emails are fetched without their
+	// attachments, or rejected. Zero means no limit.
+	MaxMessageSize uint32
+	// EmailRejectionNotices enables emailing users a copy of the direct message telling them
+	// why their reply was not posted.
+	EmailRejectionNotices bool
+	// AuthorHeader is the header identifying the author of emails, one of AuthorFrom,
+	// AuthorSender and AuthorReplyTo. Empty means AuthorFrom.
+	AuthorHeader string
+	// AddressRules normalize sender addresses that match no user.
+	AddressRules AddressRules
+	// QuoteMode is when the post replied to is quoted above the reply, one of QuoteOutOfOrder,
+	// QuoteAlways and QuoteNever. Empty means QuoteOutOfOrder.
+	QuoteMode string
+	// QuoteLength is the number of characters of the post replied to that are quoted. Zero
+	// means DefaultQuoteLength.
+	QuoteLength int
+	// LongReplyMode is how replies longer than the server accepts are posted, one of
+	// LongReplySplit and LongReplyAttachment. Empty means LongReplySplit.
+	LongReplyMode string
+	// MaxPostSize is the number of characters the server accepts in a post. Zero means
+	// model.POST_MESSAGE_MAX_RUNES_V2.
+	MaxPostSize int
+	// EmailCommands maps the lower case verbs of replies that act on the post replied to
+	// instead of being posted to their command.
+	EmailCommands map[string]EmailCommand
+	// NoticeTemplates overrides the templates of the notices telling users why their reply was
+	// not posted, by locale and message ID.
+	NoticeTemplates map[string]map[string]string
+}
+
+// Poller holds the server configuration values required to poll the IMAP mailbox of an account.
 type Poller struct {
-	api             plugin.API
-	server          string
-	security        string
-	email           string
-	password        string
-	pollingInterval int
+	api       plugin.API
+	botUserID string
+	account   Account
+	settings  Settings
+	folders   []string
+	lock      *pollLock
+	backoff   *backoff
+	breaker   *circuitBreaker
+	auditLog  *AuditLog
+	metrics   *Metrics
+	notices   noticeTemplates
+	pollNow   chan struct{}
+	ignored   *ignoredEmails
+
+	// oldPostSchema is set to 1 once the server rejected a post as too long, because its
+	// database schema has the smaller post size limit of older versions. It is accessed
+	// atomically.
+	oldPostSchema int32
+
+	// statusLock synchronizes access to status and folderStatuses.
+	statusLock     sync.RWMutex
+	status         Status
+	folderStatuses map[string]FolderStatus
 }
 
-// NewPoller creates a new Poller instance.
-func NewPoller(api plugin.API, server, security, password string, pollingInterval int) (*Poller, error) {
-	if pollingInterval <= 0 {
+// NewPoller creates a new Poller instance for the given account. Notices to system admins are
+// sent from the bot user with the given ID. What the poller does is counted in metrics, unless
+// it is nil.
+func NewPoller(api plugin.API, botUserID string, account Account, settings Settings, metrics *Metrics) (*Poller, error) {
+	if settings.PollingInterval <= 0 {
 		return nil, errors.New("pollingInterval must be greater then zero")
 	}
+	if err := account.IsValid(); err != nil {
+		return nil, err
+	}
+
+	notices, err := newNoticeTemplates(settings.NoticeTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(settings.PollingInterval) * time.Second
 
 	p := &Poller{
-		api:             api,
-		server:          server,
-		security:        security,
-		email:           *api.GetConfig().EmailSettings.ReplyToAddress,
-		password:        password,
-		pollingInterval: pollingInterval,
+		api:            api,
+		botUserID:      botUserID,
+		account:        account,
+		settings:       settings,
+		folders:        ParseFolders(account.Folders),
+		lock:           newPollLock(api, pollLockKey+account.keySuffix(), 2*interval),
+		backoff:        &backoff{interval: interval},
+		breaker:        &circuitBreaker{},
+		auditLog:       NewAuditLog(api),
+		metrics:        metrics,
+		notices:        notices,
+		pollNow:        make(chan struct{}, 1),
+		ignored:        newIgnoredEmails(),
+		folderStatuses: make(map[string]FolderStatus),
 	}
 
 	return p, nil
 }
 
-// Poll starts checking the configured email mailbox on the configured interval.
-func (p *Poller) Poll() {
-	ticker := time.NewTicker(time.Duration(p.pollingInterval) * time.Second)
-	for range ticker.C {
-		err := p.checkMailbox()
-		if err != nil {
-			p.api.LogError("Failed to poll mailbox", "error", err.Error())
+// Account returns the settings of the account polled.
+func (p *Poller) Account() Account {
+	return p.account
+}
+
+// Poll starts checking the configured email mailbox on the configured interval. It returns once
+// ctx is canceled, after finishing the message being processed at that time.
+//
+// In a cluster, only the node holding the poll lock checks the mailbox. Failed polls are retried
+// with an exponential backoff, and polling is paused for breakerCooldown after repeated
+// authentication failures.
+func (p *Poller) Poll(ctx context.Context) {
+	timer := time.NewTimer(p.backoff.interval)
+	defer timer.Stop()
+	defer p.lock.release()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			timer.Reset(p.pollOnce(ctx))
+		case <-p.pollNow:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(p.pollOnce(ctx))
 		}
 	}
 }
 
+// PollNow asks the poller to check the mailbox right away instead of waiting for the next
+// poll, including while polling is paused after repeated login failures.
+func (p *Poller) PollNow() {
+	select {
+	case p.pollNow <- struct{}{}:
+	default:
+		// A poll is already pending.
+	}
+}
+
+// pollOnce checks the mailbox if this node holds the poll lock, and returns the delay before
+// the next poll.
+func (p *Poller) pollOnce(ctx context.Context) time.Duration {
+	acquired, err := p.lock.tryAcquire()
+	if err != nil {
+		p.api.LogError("Failed to acquire poll lock", "account", p.account.Name, "error", err.Error())
+		return p.backoff.failure()
+	}
+	if !acquired {
+		p.api.LogDebug("Skipping poll, another node holds the poll lock", "account", p.account.Name)
+		return p.backoff.interval
+	}
+
+	// Stop processing after the in-flight message if another node takes over the lock.
+	lockCtx, cancel := context.WithCancel(ctx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		p.lock.heartbeat(lockCtx, cancel)
+	}()
+
+	start := time.Now()
+	err = p.checkMailbox(lockCtx)
+	cancel()
+	<-heartbeatDone
+	p.metrics.observePoll(p.account.Name, start, err)
+
+	p.setPollStatus(err)
+	defer func() {
+		p.setPaused(p.breaker.open)
+	}()
+
+	if err == nil {
+		if p.breaker.success() {
+			p.api.LogInfo("Logged into mailbox again, resuming polling", "account", p.account.Name)
+			p.notifyAdmins(fmt.Sprintf("Mailermost logged into the mailbox of %s again. Email replies are being posted again.", p.account.Email))
+		}
+		return p.backoff.success()
+	}
+
+	p.api.LogError("Failed to poll mailbox", "account", p.account.Name, "error", err.Error())
+
+	var aErr *authError
+	if !errors.As(err, &aErr) {
+		return p.backoff.failure()
+	}
+
+	if p.breaker.authFailure() {
+		p.api.LogWarn("Pausing polling after repeated login failures", "account", p.account.Name, "cooldown", breakerCooldown.String())
+		p.notifyAdmins(fmt.Sprintf("Mailermost failed to log into the mailbox of %s %d times in a row and paused polling for %s to avoid getting the account locked. Please check the IMAP settings in **System Console > Plugins > Mailermost**.\n\nLast error: `%s`", p.account.Email, breakerThreshold, breakerCooldown, err.Error()))
+	}
+	if p.breaker.open {
+		return breakerCooldown
+	}
+
+	return p.backoff.failure()
+}
+
 type replyToBatchError struct {
 	Message string
 }
@@ -74,96 +244,330 @@ func (r *replyToBatchError) Error() string {
 	return r.Message
 }
 
-func (p *Poller) checkMailbox() error {
-	c, err := newIMAPClient(p.server, p.security)
+// connect dials the IMAP server and logs into the mailbox. The caller must log out.
+func (p *Poller) connect() (*imapClient, error) {
+	defer p.metrics.observeIMAP("connect", time.Now())
+
+	c, err := newIMAPClient(p.account.Server, p.account.Security)
 	if err != nil {
-		return errors.Wrap(err, "failure connecting to IMAP server")
+		return nil, errors.Wrap(err, "failure connecting to IMAP server")
 	}
 
-	if err = c.Login(p.email, p.password); err != nil {
-		return errors.Wrapf(err, "failure loging into email for user %q", p.email)
+	if err = c.Login(p.account.username(), p.account.Password); err != nil {
+		_ = c.Terminate()
+		return nil, errors.Wrapf(&authError{err: err}, "failure loging into email for user %q", p.account.username())
 	}
-	defer func() {
-		err = c.Logout()
-		if err != nil {
-			p.api.LogError("Failed to log out of mailbox", "error", err.Error())
-		}
-	}()
 
-	mbox, err := c.Select(mailboxName, false)
+	return c, nil
+}
+
+func (p *Poller) logout(c *client.Client) {
+	if err := c.Logout(); err != nil {
+		p.api.LogError("Failed to log out of mailbox", "account", p.account.Name, "error", err.Error())
+	}
+}
+
+// TestConnection logs into the mailbox and selects each folder, without processing any email.
+func (p *Poller) TestConnection() error {
+	c, err := p.connect()
 	if err != nil {
-		return errors.Wrapf(err, "failed to get mailbox %q", mailboxName)
+		return err
 	}
+	defer p.logout(c.Client)
 
-	from := uint32(1)
-	to := mbox.Messages
+	folders, err := p.resolveFolders(c.Client)
+	if err != nil {
+		return err
+	}
+	if len(folders) == 0 {
+		return errors.New("no folder matches the configured folders")
+	}
 
-	seqset := new(imap.SeqSet)
-	seqset.AddRange(from, to)
+	for _, folder := range folders {
+		if _, err = c.Select(folder, true); err != nil {
+			return errors.Wrapf(err, "failed to get mailbox %q", folder)
+		}
+	}
 
-	messages := make(chan *imap.Message, maxEmailsPerInterval)
-	done := make(chan error, 1)
-	section := &imap.BodySectionName{}
-	go func() {
-		done <- c.Fetch(seqset, []imap.FetchItem{section.FetchItem(), imap.FetchEnvelope}, messages)
-	}()
+	return nil
+}
 
-	for msg := range messages {
-		p.processEmail(msg, section, seqset, c)
+func (p *Poller) checkMailbox(ctx context.Context) error {
+	c, err := p.connect()
+	if err != nil {
+		return err
 	}
+	defer p.logout(c.Client)
 
-	if err := <-done; err != nil {
+	folders, err := p.resolveFolders(c.Client)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	// A folder that cannot be checked, e.g. because it was removed, does not stop the others
+	// from being checked. The poll only counts as failed if no folder could be checked.
+	var lastErr error
+	checked := false
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			break
+		}
+
+		messages, folderErr := p.checkFolder(ctx, c, folder)
+		p.setFolderStatus(folder, messages, folderErr)
+		if folderErr != nil {
+			p.api.LogError("Failed to check folder", "account", p.account.Name, "folder", folder, "error", folderErr.Error())
+			lastErr = folderErr
+			continue
+		}
+		checked = true
+	}
+
+	if checked {
+		return nil
+	}
+
+	return lastErr
 }
 
-func newIMAPClient(addr, security string) (*client.Client, error) {
-	if security == "none" {
-		return client.Dial(addr)
+// checkFolder selects the given folder and processes the emails in it. It returns the number
+// of emails found.
+func (p *Poller) checkFolder(ctx context.Context, c *imapClient, folder string) (uint32, error) {
+	start := time.Now()
+	mbox, err := c.Select(folder, false)
+	p.metrics.observeIMAP("select", start)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get mailbox %q", folder)
+	}
+
+	if mbox.Messages == 0 {
+		return 0, nil
+	}
+
+	// Emails flagged as deleted in an earlier poll, but not expunged, are skipped, and
+	// so are those found not to be addressed to the account in earlier polls.
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.DeletedFlag}
+	if ignored := p.ignored.uids(folder, mbox.UidValidity); ignored != nil {
+		criteria.Not = []*imap.SearchCriteria{{Uid: ignored}}
+	}
+	start = time.Now()
+	seqNums, err := c.Search(criteria)
+	p.metrics.observeIMAP("search", start)
+	if err != nil {
+		return mbox.Messages, errors.Wrapf(err, "failed to search mailbox %q", folder)
+	}
+	if len(seqNums) == 0 {
+		return mbox.Messages, nil
+	}
+	if len(seqNums) > maxEmailsPerInterval {
+		seqNums = seqNums[:maxEmailsPerInterval]
+	}
+
+	// The whole batch is fetched before processing starts, so that the workers do not hold up
+	// the IMAP connection while they call the plugin API.
+	emails, rejected, ignored, err := p.fetchEmails(c.Client, seqNums)
+	if err != nil {
+		return mbox.Messages, errors.Wrapf(err, "failed to fetch emails from mailbox %q", folder)
 	}
-	return client.DialTLS(addr, nil)
+	for _, uid := range ignored {
+		p.ignored.add(folder, mbox.UidValidity, uid)
+	}
+	p.metrics.addFetched(p.account.Name, len(emails)+len(rejected))
+
+	if err = c.idle(); err != nil {
+		return mbox.Messages, errors.Wrap(err, "failed to lift the IMAP command timeout")
+	}
+	if err = p.deleteMessages(c.Client, append(rejected, p.processEmails(ctx, emails)...)); err != nil {
+		return mbox.Messages, err
+	}
+
+	return mbox.Messages, nil
 }
 
-func (p *Poller) processEmail(msg *imap.Message, section *imap.BodySectionName, seqset *imap.SeqSet, c *client.Client) {
-	messageID := msg.Envelope.MessageId
+// imapClient is a client of the IMAP server whose commands time out.
+type imapClient struct {
+	*client.Client
+	conn net.Conn
+}
 
-	r := msg.GetBody(section)
-	if r == nil {
-		p.api.LogError(fmt.Sprintf("failed to get message body of email %s", messageID))
-		return
+// idle lifts the deadline the client set for its last command. Otherwise the deadline would
+// close the connection while emails are processed between two commands.
+func (c *imapClient) idle() error {
+	return c.conn.SetDeadline(time.Time{})
+}
+
+// imapDialer dials the IMAP server with a timeout and keeps the connection.
+type imapDialer struct {
+	conn net.Conn
+}
+
+func (d *imapDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, imapDialTimeout)
+	if err != nil {
+		return nil, err
 	}
 
-	m, err := mail.ReadMessage(r)
+	// The greeting, and the commands the client sends before its timeout can be set, are
+	// covered by the dial timeout too.
+	if err = conn.SetDeadline(time.Now().Add(imapDialTimeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	d.conn = conn
+	return &deadlineConn{Conn: conn}, nil
+}
+
+// deadlineConn is a connection that keeps its deadline when the IMAP client lifts it, which the
+// client does for commands without a timeout. Only imapClient.idle lifts the deadline.
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+// newIMAPClient dials the IMAP server. Dialing and every command time out, so that a server
+// that stops responding cannot stall the poller, and with it stopping the pollers.
+func newIMAPClient(addr, security string) (*imapClient, error) {
+	dialer := &imapDialer{}
+
+	var c *client.Client
+	var err error
+	if security == securityNone {
+		c, err = client.DialWithDialer(dialer, addr)
+	} else {
+		c, err = client.DialWithDialerTLS(dialer, addr, nil)
+	}
+	if err != nil {
+		if dialer.conn != nil {
+			_ = dialer.conn.Close()
+		}
+		return nil, err
+	}
+	c.Timeout = imapCommandTimeout
+
+	return &imapClient{Client: c, conn: dialer.conn}, nil
+}
+
+// processEmail posts the reply in the given email, or the new post if it was sent to a channel
+// or direct message address, and reports what became of it.
+func (p *Poller) processEmail(email *inboundEmail) outcome {
+	var o outcome
+	messageID := email.envelope.MessageId
+
+	header, body, err := parseEmail(email.raw)
 	if err != nil {
 		p.api.LogError(fmt.Sprintf("failure reading email %s: %s", messageID, err.Error()))
-		return
+		return o.retry(ReasonUnreadable)
+	}
+
+	channelToken := taggedRecipient(header, p.account.Email, channelAddressTag)
+	directToken := taggedRecipient(header, p.account.Email, directAddressTag)
+	startsPost := channelToken != "" || directToken != ""
+	if !isForAccount(header, p.account.Email) {
+		p.api.LogDebug(fmt.Sprintf("email %s is not addressed to %s, leaving it in the mailbox", messageID, p.account.Email))
+		o.result = ResultIgnored
+		o.reason = ReasonNotAddressed
+		return o
 	}
 
-	body, err := ioutil.ReadAll(m.Body)
+	dedupeKey := processedKey(messageID, body)
+	processed, err := p.isProcessed(dedupeKey)
 	if err != nil {
-		p.api.LogError(fmt.Sprintf("failed to read message body of email %s: %s", messageID, err.Error()))
-		return
+		p.api.LogError(fmt.Sprintf("failed to check whether email %s was already posted: %s", messageID, err.Error()))
+		return o.retry(ReasonInternalError)
+	}
+	if processed {
+		p.api.LogInfo(fmt.Sprintf("email %s was already posted, skipping duplicate", messageID))
+		o.result = ResultDuplicate
+		return o
 	}
 
-	fromAddress := msg.Envelope.From[0].MailboxName + "@" + msg.Envelope.From[0].HostName
+	fromAddress := p.senderAddress(email.envelope)
+	if fromAddress == "" {
+		p.api.LogError(fmt.Sprintf("email %s names no sender", messageID))
+		return o.reject(ReasonNoSender)
+	}
 
-	messageText := p.extractMessage(string(body), messageID)
-	if len(messageText) == 0 {
-		p.api.LogError(fmt.Sprintf("email %s has no message text", messageID))
-		p.deleteMessage(c, seqset, messageID)
-		return
+	// New posts are not written above a quoted notification, so their whole text is posted,
+	// along with their attachments.
+	var messageText string
+	var attachments []attachment
+	if startsPost {
+		messageText, attachments, err = parseBody(header, body)
+		if err != nil {
+			p.api.LogWarn(fmt.Sprintf("failed to parse MIME parts of email %s, posting it without attachments: %s", messageID, err.Error()))
+		}
+	}
+	if !startsPost || err != nil {
+		messageText = p.extractMessage(string(body), messageID)
 	}
 
 	var appErr *model.AppError
 
-	var user *model.User
-	user, appErr = p.api.GetUserByEmail(fromAddress)
-	if appErr != nil {
-		p.api.LogError(fmt.Sprintf("failed to get user with email address %s: %s", fromAddress, appErr.Error()))
-		p.deleteMessage(c, seqset, messageID)
-		return
+	user, err := p.lookupUser(fromAddress)
+	if err != nil {
+		p.api.LogError(fmt.Sprintf("failed to look up user with email address %s: %s", fromAddress, err.Error()))
+		return o.retry(ReasonInternalError)
+	}
+	if user == nil {
+		p.api.LogError(fmt.Sprintf("no user with email address %s", fromAddress))
+		return o.reject(ReasonUnknownUser)
+	}
+	o.userID = user.Id
+
+	// Users that cannot log in are not told why their email was rejected.
+	if reason := p.authorizeSender(user); reason != ReasonNone {
+		return o.reject(reason)
+	}
+
+	// Senders that are not users are not told why their email was rejected, so that spam with
+	// forged senders is not answered.
+	reject := func(reason Reason) outcome {
+		p.noticeRejected(user, rejection{reason: reason, subject: email.envelope.Subject, text: messageText})
+		return o.reject(reason)
+	}
+
+	muted, err := IsMuted(p.api, user.Id)
+	if err != nil {
+		p.api.LogError(fmt.Sprintf("failed to check whether user %s muted email replies: %s", user.Id, err.Error()))
+		return o.retry(ReasonInternalError)
+	}
+	if muted {
+		p.api.LogInfo(fmt.Sprintf("user %s muted email replies, not posting email %s", user.Id, messageID))
+		return reject(ReasonMuted)
+	}
+
+	if startsPost {
+		// Replies can only be posted in threads of notifications the user was emailed, but new
+		// posts can be started by anyone who knows the address, so the sender must not be
+		// forged.
+		if verification := dmarcResult(header); verification != VerificationPass {
+			p.api.LogError(fmt.Sprintf("email %s starts a new post but its sender is not verified, DMARC result: %s", messageID, verification))
+			return reject(ReasonUnverifiedSender)
+		}
+
+		return p.postToChannel(o, reject, user, channelEmail{
+			messageID:   messageID,
+			token:       channelToken,
+			directToken: directToken,
+			subject:     email.envelope.Subject,
+			text:        messageText,
+			attachments: attachments,
+			props:       emailProps(header, messageID),
+			dedupeKey:   dedupeKey,
+		})
+	}
+
+	if len(messageText) == 0 {
+		p.api.LogError(fmt.Sprintf("email %s has no message text", messageID))
+		return reject(ReasonEmptyText)
 	}
 
 	postID, err := p.postIDFromEmailBody(string(body))
@@ -171,38 +575,39 @@ func (p *Poller) processEmail(msg *imap.Message, section *imap.BodySectionName,
 		var rBatchErr *replyToBatchError
 		if errors.As(err, &rBatchErr) {
 			p.api.LogError(fmt.Sprintf("apparent attempt to reply to a batched email notification by user %s", user.Id))
-			appErr = p.api.SendMail(user.Email, msg.Envelope.Subject+" - REPLY NOT POSTED", rBatchErr.Error()+"<br><br><br>> "+messageText)
-			if appErr != nil {
-				p.api.LogError(fmt.Sprintf("failure sending email to user %s", user.Id))
-				return // ...before the email is deleted.
-			}
-		} else {
-			p.api.LogError(fmt.Sprintf("post id parse error in email %s: %s", messageID, err.Error()))
+			return reject(ReasonBatchReply)
 		}
-		p.deleteMessage(c, seqset, messageID)
-		return
+		p.api.LogError(fmt.Sprintf("post id parse error in email %s: %s", messageID, err.Error()))
+		return reject(ReasonNoPostID)
 	}
+	o.postID = postID
 
 	var post *model.Post
 	post, appErr = p.api.GetPost(postID)
 	if appErr != nil {
 		p.api.LogError(fmt.Sprintf("failed to get post with id %s: %s", postID, appErr.Error()))
-		p.deleteMessage(c, seqset, messageID)
-		return
+		return reject(ReasonUnknownPost)
 	}
 
-	_, appErr = p.api.GetChannelMember(post.ChannelId, user.Id)
+	channel, appErr := p.api.GetChannel(post.ChannelId)
 	if appErr != nil {
-		p.api.LogError(fmt.Sprintf("failed to get channel member %s in channel %s: %s", user.Id, post.ChannelId, appErr.Error()))
-		p.deleteMessage(c, seqset, messageID)
-		return
+		p.api.LogError(fmt.Sprintf("failed to get channel with id %s: %s", post.ChannelId, appErr.Error()))
+		return reject(ReasonInternalError)
+	}
+
+	if reason := p.authorizeChannel(user, channel); reason != ReasonNone {
+		return reject(reason)
+	}
+
+	if len(p.account.Teams) > 0 && !p.allowsChannel(user, channel) {
+		p.api.LogError(fmt.Sprintf("post %s of email %s is not in a team served by account %q", postID, messageID, p.account.Name))
+		return reject(ReasonTeamNotServed)
 	}
 
 	postList, appErr := p.api.GetPostThread(postID)
 	if appErr != nil {
 		p.api.LogError(fmt.Sprintf("failed to get post thread for post id %s: %s", postID, appErr.Error()))
-		p.deleteMessage(c, seqset, messageID)
-		return
+		return reject(ReasonInternalError)
 	}
 
 	threadPosts := make([]*model.Post, 0)
@@ -216,29 +621,33 @@ func (p *Poller) processEmail(msg *imap.Message, section *imap.BodySectionName,
 	rootPost := threadPosts[len(threadPosts)-1]
 	lastPost := threadPosts[0]
 
-	if len(postList.Posts) > 1 && lastPost.Id != post.Id {
-		var channel *model.Channel
-		channel, appErr = p.api.GetChannel(post.ChannelId)
-		if appErr != nil {
-			p.api.LogError(fmt.Sprintf("failed to get channel with id %s: %s", post.ChannelId, appErr.Error()))
-			p.deleteMessage(c, seqset, messageID)
-			return
+	if command, ok := p.emailCommand(messageText); ok {
+		if err = p.runCommand(user, post, rootPost, command); err != nil {
+			if err == ErrTooManyFollowers {
+				p.api.LogError(fmt.Sprintf("user %s cannot follow thread %s: %s", user.Id, rootPost.Id, err.Error()))
+				return reject(ReasonTooManyFollowers)
+			}
+			p.api.LogError(fmt.Sprintf("failed to run email command %s of email %s: %s", command.Action, messageID, err.Error()))
+			return o.retry(ReasonPostFailed)
 		}
 
-		var team *model.Team
-		team, appErr = p.api.GetTeam(channel.TeamId)
-		if appErr != nil {
-			p.api.LogError(fmt.Sprintf("failed to get team with id %s: %s", channel.TeamId, appErr.Error()))
-			p.deleteMessage(c, seqset, messageID)
-			return
+		if err = p.markProcessed(dedupeKey); err != nil {
+			p.api.LogError(fmt.Sprintf("failed to mark email %s as posted: %s", messageID, err.Error()))
 		}
 
-		postPl := "/" + team.Name + "/pl/" + post.Id
+		o.result = ResultPosted
+		return o
+	}
 
-		if len(post.Message) > ellipsisLen {
-			messageText = fmt.Sprintf("> [%s](%s)...\n\n%s", post.Message[:ellipsisLen], postPl, messageText)
-		} else {
-			messageText = fmt.Sprintf("> [%s](%s)\n\n%s", post.Message, postPl, messageText)
+	if p.quotes(len(postList.Posts) == 1 || lastPost.Id == post.Id) {
+		var quote string
+		quote, err = p.quotePost(post, channel)
+		if err != nil {
+			p.api.LogError(fmt.Sprintf("failed to quote post %s: %s", post.Id, err.Error()))
+			return reject(ReasonInternalError)
+		}
+		if quote != "" {
+			messageText = quote + "\n\n" + messageText
 		}
 	}
 
@@ -248,25 +657,97 @@ func (p *Poller) processEmail(msg *imap.Message, section *imap.BodySectionName,
 		Message:   messageText,
 		ParentId:  rootPost.Id,
 		RootId:    rootPost.Id,
+		Props:     emailProps(header, messageID),
 	}
 
-	_, appErr = p.api.CreatePost(newPost)
-	if appErr != nil {
-		p.api.LogError(fmt.Sprintf("failed to create post %+v: %s", newPost, appErr.Error()))
+	if _, err = p.createPosts(newPost); err != nil {
+		p.api.LogError(fmt.Sprintf("failed to create post %+v: %s", newPost, err.Error()))
 		// Do not delete the inbound email in this failure case because everything about the inbound email has been valid so far.
-		return
+		return o.retry(ReasonPostFailed)
+	}
+
+	if err = p.markProcessed(dedupeKey); err != nil {
+		p.api.LogError(fmt.Sprintf("failed to mark email %s as posted: %s", messageID, err.Error()))
+	}
+
+	o.result = ResultPosted
+	return o
+}
+
+// allowsChannel reports whether the channel belongs to one of the teams the account is
+// restricted to. Direct and group message channels belong to no team, so they are allowed if the
+// user is a member of one of the teams.
+func (p *Poller) allowsChannel(user *model.User, channel *model.Channel) bool {
+	if channel.TeamId == "" {
+		teams, appErr := p.api.GetTeamsForUser(user.Id)
+		if appErr != nil {
+			p.api.LogError(fmt.Sprintf("failed to get teams of user %s: %s", user.Id, appErr.Error()))
+			return false
+		}
+
+		for _, team := range teams {
+			if p.account.AllowsTeam(team.Name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	team, appErr := p.api.GetTeam(channel.TeamId)
+	if appErr != nil {
+		p.api.LogError(fmt.Sprintf("failed to get team with id %s: %s", channel.TeamId, appErr.Error()))
+		return false
 	}
 
-	p.deleteMessage(c, seqset, messageID)
+	return p.account.AllowsTeam(team.Name)
 }
 
-func (p *Poller) deleteMessage(c *client.Client, seqset *imap.SeqSet, messageID string) {
+// deleteMessages flags the emails with the given UIDs as deleted in a single batch. If the
+// server supports UIDPLUS, they are also expunged, leaving alone the emails other clients
+// flagged as deleted.
+func (p *Poller) deleteMessages(c *client.Client, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uids...)
+
+	defer p.metrics.observeIMAP("delete", time.Now())
+
 	item := imap.FormatFlagsOp(imap.AddFlags, true)
 	flags := []interface{}{imap.DeletedFlag}
-	err := c.Store(seqset, item, flags, nil)
+	if err := c.UidStore(uidset, item, flags, nil); err != nil {
+		return errors.Wrapf(err, "failed to set deleted flag on %d emails", len(uids))
+	}
+
+	supported, err := c.Support("UIDPLUS")
 	if err != nil {
-		p.api.LogError(fmt.Sprintf("failed to set deleted flag on email %q: %s", messageID, err.Error()))
+		return errors.Wrap(err, "failed to get server capabilities")
+	}
+	if !supported {
+		return nil
 	}
+
+	status, err := c.Execute(&uidExpunge{uids: uidset}, nil)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to expunge %d deleted emails", len(uids))
+	}
+
+	return nil
+}
+
+// uidExpunge is the UID EXPUNGE command of the UIDPLUS extension, which only expunges the
+// emails with the given UIDs.
+type uidExpunge struct {
+	uids *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{Name: "UID EXPUNGE", Arguments: []interface{}{cmd.uids}}
 }
 
 func (p *Poller) postIDFromEmailBody(emailBody string) (string, error) {
@@ -297,9 +778,7 @@ func (p *Poller) postIDFromEmailBody(emailBody string) (string, error) {
 func (p *Poller) extractMessage(body string, messageID string) string {
 	var extractor extractors.IExtractor
 
-	clientType := messageID[strings.Index(messageID, "@")+1 : len(messageID)-1]
-
-	if clientType == "mozgaia" {
+	if clientType(messageID) == clientMozGaia {
 		extractor = extractors.MozGaiaExtractor{}
 	} else {
 		extractor = extractors.DefaultExtractor{}
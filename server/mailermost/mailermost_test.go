@@ -0,0 +1,120 @@
+package mailermost
+
+import (
+	"fmt"
+	"testing"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPoller returns a poller of the account replies@example.org, logging to api.
+func newTestPoller(t *testing.T, api *plugintest.API) *Poller {
+	notices, err := newNoticeTemplates(nil)
+	require.NoError(t, err)
+
+	api.On("LogDebug", mock.Anything).Maybe()
+	api.On("LogInfo", mock.Anything).Maybe()
+	api.On("LogWarn", mock.Anything).Maybe()
+	api.On("LogError", mock.Anything).Maybe()
+
+	return &Poller{
+		api:       api,
+		botUserID: "bot",
+		account:   Account{Name: "replies", Email: "replies@example.org"},
+		notices:   notices,
+	}
+}
+
+// testEmail returns an email from alice@example.org to the given address, with the given
+// extra header lines.
+func testEmail(uid uint32, to, headers, text string) *inboundEmail {
+	messageID := fmt.Sprintf("<%d@example.org>", uid)
+	raw := fmt.Sprintf("From: alice@example.org\r\nTo: %s\r\nMessage-ID: %s\r\nSubject: Lunch\r\n%sContent-Type: text/plain\r\n\r\n%s\r\n", to, messageID, headers, text)
+
+	return &inboundEmail{
+		uid: uid,
+		envelope: &imap.Envelope{
+			MessageId: messageID,
+			Subject:   "Lunch",
+			From:      []*imap.Address{{MailboxName: "alice", HostName: "example.org"}},
+		},
+		raw: []byte(raw),
+	}
+}
+
+func TestProcessEmailDirectMessage(t *testing.T) {
+	const (
+		directAddress = "replies+dm-bobtoken@example.org"
+		dmarcPass     = "Authentication-Results: mx.example.org; dmarc=pass header.from=example.org\r\n"
+	)
+	alice := &model.User{Id: "alice", Username: "alice", Email: "alice@example.org", Roles: model.SYSTEM_USER_ROLE_ID}
+
+	mockAPI := func(t *testing.T) *plugintest.API {
+		config := &model.Config{}
+		config.SetDefaults()
+
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(config).Maybe()
+		api.On("GetUserByEmail", "alice@example.org").Return(alice, nil)
+		api.On("KVGet", directAddressKeyPrefix+"bobtoken").Return([]byte("bob"), nil).Maybe()
+		api.On("KVGet", mock.Anything).Return(nil, nil)
+		return api
+	}
+
+	t.Run("verified sender", func(t *testing.T) {
+		api := mockAPI(t)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+
+		dm := &model.Channel{Id: "dm", Type: model.CHANNEL_DIRECT}
+		api.On("GetUser", "bob").Return(&model.User{Id: "bob"}, nil).Once()
+		api.On("GetDirectChannel", "alice", "bob").Return(dm, nil).Once()
+		api.On("GetChannelMember", "dm", "alice").Return(&model.ChannelMember{}, nil).Once()
+		api.On("HasPermissionToChannel", "alice", "dm", model.PERMISSION_CREATE_POST).Return(true).Once()
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.ChannelId == "dm" && post.UserId == "alice" && post.Message == "**Lunch**\n\nNoon?"
+		})).Return(&model.Post{Id: "post"}, nil).Once()
+		api.On("KVSetWithExpiry", mock.Anything, mock.Anything, processedTTL).Return(nil).Once()
+
+		o := p.processEmail(testEmail(1, directAddress, dmarcPass, "Noon?"))
+		assert.Equal(t, ResultPosted, o.result)
+		assert.Equal(t, "post", o.postID)
+	})
+
+	t.Run("unverified sender", func(t *testing.T) {
+		api := mockAPI(t)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+
+		for _, headers := range []string{"", "Authentication-Results: mx.example.org; dmarc=fail header.from=example.org\r\n"} {
+			// Only the notice to the user is posted.
+			api.On("GetDirectChannel", "alice", "bot").Return(&model.Channel{Id: "notices"}, nil).Once()
+			api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.ChannelId == "notices" && post.UserId == "bot"
+			})).Return(&model.Post{}, nil).Once()
+
+			o := p.processEmail(testEmail(2, directAddress, headers, "Noon?"))
+			assert.Equal(t, ResultRejected, o.result)
+			assert.Equal(t, ReasonUnverifiedSender, o.reason)
+		}
+		api.AssertNotCalled(t, "GetUser", "bob")
+	})
+
+	t.Run("guessed address", func(t *testing.T) {
+		api := mockAPI(t)
+		defer api.AssertExpectations(t)
+		p := newTestPoller(t, api)
+
+		api.On("GetDirectChannel", "alice", "bot").Return(&model.Channel{Id: "notices"}, nil).Once()
+		api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil).Once()
+
+		o := p.processEmail(testEmail(3, "replies+dm-bob@example.org", dmarcPass, "Noon?"))
+		assert.Equal(t, ResultRejected, o.result)
+		assert.Equal(t, ReasonUnknownRecipient, o.reason)
+	})
+}
@@ -0,0 +1,213 @@
+package mailermost
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	imapLatencyBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	pollDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+// Metrics counts what the pollers do, for export in the Prometheus text format. A nil *Metrics
+// records nothing. The counts are kept in memory and cover this server only.
+type Metrics struct {
+	lock sync.Mutex
+
+	fetched            map[string]float64
+	posted             map[string]float64
+	rejected           map[[2]string]float64
+	deferred           map[[2]string]float64
+	imapLatency        map[string]*histogram
+	pollDuration       map[string]*histogram
+	lastSuccessfulPoll map[string]float64
+}
+
+// NewMetrics creates a new Metrics instance.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		fetched:            make(map[string]float64),
+		posted:             make(map[string]float64),
+		rejected:           make(map[[2]string]float64),
+		deferred:           make(map[[2]string]float64),
+		imapLatency:        make(map[string]*histogram),
+		pollDuration:       make(map[string]*histogram),
+		lastSuccessfulPoll: make(map[string]float64),
+	}
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []float64
+	sum     float64
+	count   float64
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func observe(histograms map[string]*histogram, key string, buckets []float64, value float64) {
+	h, ok := histograms[key]
+	if !ok {
+		h = &histogram{buckets: buckets, counts: make([]float64, len(buckets))}
+		histograms[key] = h
+	}
+	h.observe(value)
+}
+
+func (m *Metrics) addFetched(account string, count int) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.fetched[account] += float64(count)
+}
+
+func (m *Metrics) recordOutcome(account string, o outcome) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch o.result {
+	case ResultPosted:
+		m.posted[account]++
+	case ResultRejected:
+		m.rejected[[2]string{account, string(o.reason)}]++
+	case ResultDeferred:
+		m.deferred[[2]string{account, string(o.reason)}]++
+	}
+}
+
+// observeIMAP records the time an IMAP operation took since start.
+func (m *Metrics) observeIMAP(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	observe(m.imapLatency, operation, imapLatencyBuckets, time.Since(start).Seconds())
+}
+
+// observePoll records the time a poll took since start, and when it succeeded.
+func (m *Metrics) observePoll(account string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	observe(m.pollDuration, account, pollDurationBuckets, time.Since(start).Seconds())
+	if err == nil {
+		m.lastSuccessfulPoll[account] = float64(time.Now().Unix())
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var sb strings.Builder
+
+	writeCounter(&sb, "mailermost_emails_fetched_total", "Emails fetched from the mailboxes.", m.fetched)
+	writeCounter(&sb, "mailermost_emails_posted_total", "Emails posted as replies.", m.posted)
+	writeReasonCounter(&sb, "mailermost_emails_rejected_total", "Emails deleted without posting, by reason.", m.rejected)
+	writeReasonCounter(&sb, "mailermost_emails_deferred_total", "Emails kept in the mailbox to retry, by reason.", m.deferred)
+	writeHistograms(&sb, "mailermost_imap_latency_seconds", "Time taken by IMAP operations.", "operation", m.imapLatency)
+	writeHistograms(&sb, "mailermost_poll_duration_seconds", "Time taken to check a mailbox.", "account", m.pollDuration)
+
+	sb.WriteString("# HELP mailermost_last_successful_poll_timestamp_seconds Time the mailbox was last checked successfully.\n")
+	sb.WriteString("# TYPE mailermost_last_successful_poll_timestamp_seconds gauge\n")
+	for _, account := range sortedKeys(m.lastSuccessfulPoll) {
+		fmt.Fprintf(&sb, "mailermost_last_successful_poll_timestamp_seconds{account=%q} %s\n", account, formatFloat(m.lastSuccessfulPoll[account]))
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func writeCounter(sb *strings.Builder, name, help string, values map[string]float64) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, account := range sortedKeys(values) {
+		fmt.Fprintf(sb, "%s{account=%q} %s\n", name, account, formatFloat(values[account]))
+	}
+}
+
+func writeReasonCounter(sb *strings.Builder, name, help string, values map[[2]string]float64) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+
+	keys := make([][2]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	for _, key := range keys {
+		fmt.Fprintf(sb, "%s{account=%q,reason=%q} %s\n", name, key[0], key[1], formatFloat(values[key]))
+	}
+}
+
+func writeHistograms(sb *strings.Builder, name, help, label string, histograms map[string]*histogram) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	keys := make([]string, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := histograms[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(sb, "%s_bucket{%s=%q,le=%q} %s\n", name, label, key, formatFloat(bound), formatFloat(h.counts[i]))
+		}
+		fmt.Fprintf(sb, "%s_bucket{%s=%q,le=\"+Inf\"} %s\n", name, label, key, formatFloat(h.count))
+		fmt.Fprintf(sb, "%s_sum{%s=%q} %s\n", name, label, key, formatFloat(h.sum))
+		fmt.Fprintf(sb, "%s_count{%s=%q} %s\n", name, label, key, formatFloat(h.count))
+	}
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,51 @@
+package mailermost
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("nil metrics record nothing", func(t *testing.T) {
+		var m *Metrics
+		m.addFetched("", 1)
+		m.recordOutcome("", outcome{result: ResultPosted})
+		m.observeIMAP("fetch", time.Now())
+
+		var sb strings.Builder
+		n, err := m.WriteTo(&sb)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("write text exposition format", func(t *testing.T) {
+		m := NewMetrics()
+		m.addFetched("sales", 3)
+		m.recordOutcome("sales", outcome{result: ResultPosted})
+		m.recordOutcome("sales", outcome{}.reject(ReasonUnknownUser))
+		m.recordOutcome("sales", outcome{}.reject(ReasonUnknownUser))
+		m.recordOutcome("sales", outcome{}.retry(ReasonPostFailed))
+		m.recordOutcome("sales", outcome{result: ResultIgnored})
+		m.observeIMAP("fetch", time.Now().Add(-300*time.Millisecond))
+		m.observePoll("sales", time.Now(), nil)
+
+		var sb strings.Builder
+		_, err := m.WriteTo(&sb)
+		require.NoError(t, err)
+		text := sb.String()
+
+		assert.Contains(t, text, "# TYPE mailermost_emails_fetched_total counter\nmailermost_emails_fetched_total{account=\"sales\"} 3\n")
+		assert.Contains(t, text, "mailermost_emails_posted_total{account=\"sales\"} 1\n")
+		assert.Contains(t, text, "mailermost_emails_rejected_total{account=\"sales\",reason=\"unknown_user\"} 2\n")
+		assert.Contains(t, text, "mailermost_emails_deferred_total{account=\"sales\",reason=\"post_failed\"} 1\n")
+		assert.Contains(t, text, "mailermost_imap_latency_seconds_bucket{operation=\"fetch\",le=\"0.25\"} 0\n")
+		assert.Contains(t, text, "mailermost_imap_latency_seconds_bucket{operation=\"fetch\",le=\"0.5\"} 1\n")
+		assert.Contains(t, text, "mailermost_imap_latency_seconds_bucket{operation=\"fetch\",le=\"+Inf\"} 1\n")
+		assert.Contains(t, text, "mailermost_poll_duration_seconds_count{account=\"sales\"} 1\n")
+		assert.Contains(t, text, "mailermost_last_successful_poll_timestamp_seconds{account=\"sales\"} ")
+	})
+}
@@ -0,0 +1,122 @@
+package mailermost
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// attachment is a file attached to an email.
+type attachment struct {
+	name string
+	data []byte
+}
+
+// mimePart is the header and body of an email or one of its MIME parts.
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   io.Reader
+}
+
+// parseBody returns the text of an email and its attachments. The plain text part is preferred
+// over the HTML part, which is used as is.
+func parseBody(header mail.Header, body []byte) (string, []attachment, error) {
+	var plain, html string
+	var attachments []attachment
+
+	var walk func(part mimePart) error
+	walk = func(part mimePart) error {
+		mediaType, params, err := mime.ParseMediaType(part.header.Get("Content-Type"))
+		if err != nil {
+			mediaType = "text/plain"
+		}
+
+		if strings.HasPrefix(mediaType, "multipart/") {
+			reader := multipart.NewReader(part.body, params["boundary"])
+			for {
+				child, nextErr := reader.NextRawPart()
+				if nextErr == io.EOF {
+					return nil
+				}
+				if nextErr != nil {
+					return errors.Wrap(nextErr, "failed to read MIME part")
+				}
+				if err = walk(mimePart{header: child.Header, body: child}); err != nil {
+					return err
+				}
+			}
+		}
+
+		data, err := ioutil.ReadAll(decodeTransferEncoding(part))
+		if err != nil {
+			return errors.Wrap(err, "failed to decode MIME part")
+		}
+
+		if name := attachmentName(part.header, params); name != "" {
+			attachments = append(attachments, attachment{name: name, data: data})
+			return nil
+		}
+
+		switch {
+		case mediaType == "text/plain" && plain == "":
+			plain = string(data)
+		case mediaType == "text/html" && html == "":
+			html = string(data)
+		}
+
+		return nil
+	}
+
+	if err := walk(mimePart{header: textproto.MIMEHeader(header), body: bytes.NewReader(body)}); err != nil {
+		return "", nil, err
+	}
+
+	text := plain
+	if text == "" {
+		text = html
+	}
+
+	return strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1)), attachments, nil
+}
+
+func decodeTransferEncoding(part mimePart) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(part.header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, part.body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(part.body)
+	default:
+		return part.body
+	}
+}
+
+// attachmentName returns the file name of a MIME part that is an attachment, or an empty string.
+func attachmentName(header textproto.MIMEHeader, contentTypeParams map[string]string) string {
+	disposition, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := params["filename"]
+	if name == "" {
+		name = contentTypeParams["name"]
+	}
+	if err == nil && disposition == "attachment" && name == "" {
+		name = "attachment"
+	}
+	if name == "" {
+		return ""
+	}
+
+	decoder := new(mime.WordDecoder)
+	if decoded, decodeErr := decoder.DecodeHeader(name); decodeErr == nil {
+		name = decoded
+	}
+
+	return name
+}
@@ -0,0 +1,51 @@
+package mailermost
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBody(t *testing.T) {
+	raw := strings.Join([]string{
+		"Content-Type: multipart/mixed; boundary=outer",
+		"",
+		"--outer",
+		"Content-Type: multipart/alternative; boundary=inner",
+		"",
+		"--inner",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Caf=C3=A9 at noon?",
+		"--inner",
+		"Content-Type: text/html; charset=utf-8",
+		"",
+		"<p>Café at noon?</p>",
+		"--inner--",
+		"--outer",
+		"Content-Type: text/plain; name=\"=?utf-8?q?men=C3=BC.txt?=\"",
+		"Content-Disposition: attachment",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"c291cA==",
+		"--outer--",
+		"",
+	}, "\r\n")
+
+	m, err := mail.ReadMessage(strings.NewReader(raw))
+	require.NoError(t, err)
+	header, body, err := parseEmail([]byte(raw))
+	require.NoError(t, err)
+	require.Equal(t, m.Header, header)
+
+	text, attachments, err := parseBody(header, body)
+	require.NoError(t, err)
+	assert.Equal(t, "Café at noon?", text)
+	require.Len(t, attachments, 1)
+	assert.Equal(t, "menü.txt", attachments[0].name)
+	assert.Equal(t, "soup", string(attachments[0].data))
+}
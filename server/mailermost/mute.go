@@ -0,0 +1,36 @@
+package mailermost
+
+import (
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const mutedKeyPrefix string = "muted_"
+
+// SetMuted sets whether email replies of the given user are rejected instead of posted.
+func SetMuted(api plugin.API, userID string, muted bool) error {
+	key := mutedKeyPrefix + userID
+
+	if !muted {
+		if appErr := api.KVDelete(key); appErr != nil {
+			return errors.Wrapf(appErr, "failed to unmute user %s", userID)
+		}
+		return nil
+	}
+
+	if appErr := api.KVSet(key, []byte("true")); appErr != nil {
+		return errors.Wrapf(appErr, "failed to mute user %s", userID)
+	}
+
+	return nil
+}
+
+// IsMuted reports whether email replies of the given user are rejected instead of posted.
+func IsMuted(api plugin.API, userID string) (bool, error) {
+	value, appErr := api.KVGet(mutedKeyPrefix + userID)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "failed to get mute setting of user %s", userID)
+	}
+
+	return value != nil, nil
+}
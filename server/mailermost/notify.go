@@ -0,0 +1,134 @@
+package mailermost
+
+import (
+	"html"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const adminsPerPage = 100
+
+// NotifyAdmins sends message as a direct message from the bot user with the given ID to every
+// system admin.
+func NotifyAdmins(api plugin.API, botUserID, message string) {
+	for page := 0; ; page++ {
+		admins, appErr := api.GetUsers(&model.UserGetOptions{
+			Role:    model.SYSTEM_ADMIN_ROLE_ID,
+			Page:    page,
+			PerPage: adminsPerPage,
+		})
+		if appErr != nil {
+			api.LogError("Failed to get system admins", "error", appErr.Error())
+			return
+		}
+
+		for _, admin := range admins {
+			if err := sendDirectMessage(api, botUserID, admin.Id, message); err != nil {
+				api.LogError("Failed to notify system admin", "user_id", admin.Id, "error", err.Error())
+			}
+		}
+
+		if len(admins) < adminsPerPage {
+			return
+		}
+	}
+}
+
+// NotifyUser sends message to the user with the given ID as a direct message from the bot user
+// with the given ID.
+func NotifyUser(api plugin.API, botUserID, userID, message string) {
+	if err := sendDirectMessage(api, botUserID, userID, message); err != nil {
+		api.LogError("Failed to notify user", "user_id", userID, "error", err.Error())
+	}
+}
+
+// sendDirectMessage posts message in the direct message channel between the bot user with the
+// given ID and the given user.
+func sendDirectMessage(api plugin.API, botUserID, userID, message string) error {
+	channel, appErr := api.GetDirectChannel(userID, botUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get direct channel")
+	}
+
+	post := &model.Post{
+		UserId:    botUserID,
+		ChannelId: channel.Id,
+		Message:   message,
+	}
+	if _, appErr = api.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to send direct message")
+	}
+
+	return nil
+}
+
+func (p *Poller) notifyAdmins(message string) {
+	NotifyAdmins(p.api, p.botUserID, message)
+}
+
+// rejection describes a reply that was not posted, for telling its author why.
+type rejection struct {
+	reason Reason
+	// subject is the subject of the email.
+	subject string
+	// text is the reply the user wrote. It is empty if the text was not read.
+	text string
+	// size is the size of the email, for ReasonTooLarge.
+	size uint32
+}
+
+// noticeRejected tells the user by direct message from the bot, and by email if enabled, why
+// their reply was not posted, quoting what they wrote. The notice is rendered in the locale of
+// the user. Failures are logged, the email is deleted regardless.
+func (p *Poller) noticeRejected(user *model.User, r rejection) {
+	data := noticeData{
+		Username: user.Username,
+		Subject:  r.subject,
+		Size:     formatSize(r.size),
+		MaxSize:  formatSize(p.settings.MaxMessageSize),
+	}
+	render := func(id string) string {
+		text, _, err := p.notices.render(user.Locale, id, data)
+		if err != nil {
+			p.api.LogError("Failed to render notice", "user_id", user.Id, "error", err.Error())
+		}
+		return text
+	}
+
+	explanation, ok, err := p.notices.render(user.Locale, string(r.reason), data)
+	if err != nil {
+		p.api.LogError("Failed to render notice", "user_id", user.Id, "error", err.Error())
+		return
+	}
+	if !ok {
+		return
+	}
+
+	message := render(msgIntro) + " " + explanation
+	if r.text != "" {
+		message += "\n\n" + render(msgQuote) + "\n\n" + quote(r.text)
+	}
+	if err = sendDirectMessage(p.api, p.botUserID, user.Id, message); err != nil {
+		p.api.LogError("Failed to tell user why their reply was not posted", "user_id", user.Id, "error", err.Error())
+	}
+
+	if !p.settings.EmailRejectionNotices {
+		return
+	}
+
+	body := html.EscapeString(render(msgEmailIntro) + " " + explanation)
+	if r.text != "" {
+		body += "<br><br>" + html.EscapeString(render(msgQuote)) + "<blockquote>" + strings.ReplaceAll(html.EscapeString(r.text), "\n", "<br>") + "</blockquote>"
+	}
+	if appErr := p.api.SendMail(user.Email, render(msgEmailSubject), body); appErr != nil {
+		p.api.LogError("Failed to email user why their reply was not posted", "user_id", user.Id, "error", appErr.Error())
+	}
+}
+
+// quote formats text as a Markdown block quote.
+func quote(text string) string {
+	return "> " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n> ")
+}
@@ -0,0 +1,69 @@
+package mailermost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoticeRejected(t *testing.T) {
+	user := &model.User{Id: "user", Email: "user@example.org"}
+	notices, err := newNoticeTemplates(nil)
+	require.NoError(t, err)
+
+	t.Run("direct message quotes the reply", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("GetDirectChannel", "user", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.UserId == "bot" && post.ChannelId == "dm" &&
+				strings.Contains(post.Message, "**Re: Hello**") &&
+				strings.Contains(post.Message, "not a member of the channel") &&
+				strings.HasSuffix(post.Message, "> first line\n> second line")
+		})).Return(&model.Post{}, nil)
+
+		p := &Poller{api: api, botUserID: "bot", notices: notices}
+		p.noticeRejected(user, rejection{reason: ReasonNotChannelMember, subject: "Re: Hello", text: "first line\nsecond line\n"})
+	})
+
+	t.Run("email copy when enabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("GetDirectChannel", "user", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+		api.On("SendMail", "user@example.org", "Re: Hello - REPLY NOT POSTED", mock.MatchedBy(func(body string) bool {
+			return strings.Contains(body, "archived") && strings.Contains(body, "&lt;b&gt;")
+		})).Return(nil)
+
+		p := &Poller{api: api, botUserID: "bot", settings: Settings{EmailRejectionNotices: true}, notices: notices}
+		p.noticeRejected(user, rejection{reason: ReasonArchivedChannel, subject: "Re: Hello", text: "<b>"})
+	})
+
+	t.Run("notice in the locale of the user", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		api.On("GetDirectChannel", "user", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return strings.HasPrefix(post.Message, "Deine E-Mail-Antwort **Re: Hallo**") && strings.Contains(post.Message, "archiviert")
+		})).Return(&model.Post{}, nil)
+
+		germanUser := &model.User{Id: "user", Locale: "de"}
+		p := &Poller{api: api, botUserID: "bot", notices: notices}
+		p.noticeRejected(germanUser, rejection{reason: ReasonArchivedChannel, subject: "Re: Hallo"})
+	})
+
+	t.Run("no notice for reasons without a template", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := &Poller{api: api, botUserID: "bot", settings: Settings{EmailRejectionNotices: true}, notices: notices}
+		p.noticeRejected(user, rejection{reason: ReasonUnknownUser, subject: "Re: Hello"})
+	})
+}
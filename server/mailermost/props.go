@@ -0,0 +1,88 @@
+package mailermost
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// The props of posts created from emails, for other plugins and exports to tell them apart.
+const (
+	// PropSource is SourceEmail for posts created from emails.
+	PropSource = "source"
+	// PropMessageID is the Message-ID of the email.
+	PropMessageID = "email_message_id"
+	// PropClient is the email client the email was sent with, if known.
+	PropClient = "email_client"
+	// PropVerification is the DMARC result of the email, as recorded by the receiving mail
+	// server in the Authentication-Results header, e.g. pass or fail. It is VerificationNone
+	// if there is no result.
+	PropVerification = "email_verification"
+
+	// SourceEmail is the PropSource of posts created from emails.
+	SourceEmail = "email"
+	// VerificationPass is the PropVerification of emails that passed DMARC.
+	VerificationPass = "pass"
+	// VerificationNone is the PropVerification of emails without a DMARC result.
+	VerificationNone = "none"
+)
+
+// clientMozGaia is the client type of the KaiOS email client, which needs its own extractor.
+const clientMozGaia = "mozgaia"
+
+// emailProps returns the props of a post created from the email with the given header.
+func emailProps(header mail.Header, messageID string) model.StringInterface {
+	props := model.StringInterface{
+		PropSource:       SourceEmail,
+		PropMessageID:    messageID,
+		PropVerification: dmarcResult(header),
+	}
+	if client := emailClient(header, messageID); client != "" {
+		props[PropClient] = client
+	}
+
+	return props
+}
+
+// emailClient returns the email client named by the X-Mailer or User-Agent header, or the
+// client type given by the domain of the Message-ID if it is one the plugin knows.
+func emailClient(header mail.Header, messageID string) string {
+	for _, key := range []string{"X-Mailer", "User-Agent"} {
+		if client := strings.TrimSpace(header.Get(key)); client != "" {
+			return client
+		}
+	}
+
+	if clientType(messageID) == clientMozGaia {
+		return clientMozGaia
+	}
+
+	return ""
+}
+
+// clientType returns the domain of a Message-ID like <id@domain>, which some clients set to
+// their name.
+func clientType(messageID string) string {
+	at := strings.Index(messageID, "@")
+	if at < 0 {
+		return ""
+	}
+
+	return strings.TrimSuffix(messageID[at+1:], ">")
+}
+
+// dmarcResult returns the DMARC result in the topmost Authentication-Results header, which the
+// receiving mail server adds, or VerificationNone. Lower headers may have been forged by the
+// sender.
+func dmarcResult(header mail.Header) string {
+	results := header.Get("Authentication-Results")
+	for _, part := range strings.Split(results, ";") {
+		fields := strings.Fields(strings.ToLower(part))
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "dmarc=") {
+			return strings.TrimPrefix(fields[0], "dmarc=")
+		}
+	}
+
+	return VerificationNone
+}
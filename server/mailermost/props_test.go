@@ -0,0 +1,37 @@
+package mailermost
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailProps(t *testing.T) {
+	header := mail.Header{
+		"X-Mailer": {"Apple Mail (2.3608)"},
+		"Authentication-Results": {
+			"mx.example.org; dkim=pass header.d=example.com; spf=pass smtp.mailfrom=example.com; DMARC=Pass header.from=example.com",
+			"forged.example.com; dmarc=fail",
+		},
+	}
+	assert.Equal(t, model.StringInterface{
+		PropSource:       SourceEmail,
+		PropMessageID:    "<abc@example.com>",
+		PropClient:       "Apple Mail (2.3608)",
+		PropVerification: "pass",
+	}, emailProps(header, "<abc@example.com>"))
+
+	assert.Equal(t, model.StringInterface{
+		PropSource:       SourceEmail,
+		PropMessageID:    "<abc@mozgaia>",
+		PropClient:       clientMozGaia,
+		PropVerification: VerificationNone,
+	}, emailProps(mail.Header{}, "<abc@mozgaia>"))
+}
+
+func TestClientType(t *testing.T) {
+	assert.Equal(t, "mozgaia", clientType("<abc@mozgaia>"))
+	assert.Equal(t, "", clientType(""))
+}
@@ -0,0 +1,104 @@
+package mailermost
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// The modes of quoting the post replied to above a reply.
+const (
+	// QuoteOutOfOrder quotes the post replied to if it is not the latest post of the thread.
+	QuoteOutOfOrder = "out_of_order"
+	// QuoteAlways always quotes the post replied to.
+	QuoteAlways = "always"
+	// QuoteNever never quotes the post replied to.
+	QuoteNever = "never"
+)
+
+// DefaultQuoteLength is the number of characters of the post replied to that are quoted if no
+// length is set.
+const DefaultQuoteLength = 50
+
+// ValidateQuoteMode checks the mode of quoting the post replied to. An empty mode means
+// QuoteOutOfOrder.
+func ValidateQuoteMode(mode string) error {
+	switch mode {
+	case "", QuoteOutOfOrder, QuoteAlways, QuoteNever:
+		return nil
+	default:
+		return errors.Errorf("unknown quote mode %q, expected %s, %s or %s", mode, QuoteOutOfOrder, QuoteAlways, QuoteNever)
+	}
+}
+
+// quotes reports whether the post replied to is quoted above the reply, given whether it is the
+// latest post of its thread.
+func (p *Poller) quotes(latest bool) bool {
+	switch p.settings.QuoteMode {
+	case QuoteAlways:
+		return true
+	case QuoteNever:
+		return false
+	default:
+		return !latest
+	}
+}
+
+// quotePost returns the Markdown quote of the post replied to, linking to it if the channel
+// belongs to a team. It is empty if the post has no text.
+func (p *Poller) quotePost(post *model.Post, channel *model.Channel) (string, error) {
+	length := p.settings.QuoteLength
+	if length <= 0 {
+		length = DefaultQuoteLength
+	}
+
+	text, truncated := truncateQuote(post.Message, length)
+	if text == "" {
+		return "", nil
+	}
+	ellipsis := ""
+	if truncated {
+		ellipsis = "..."
+	}
+
+	// Direct and group messages have no team to link the quoted message in.
+	if channel.TeamId == "" {
+		return fmt.Sprintf("> %s%s", text, ellipsis), nil
+	}
+
+	team, appErr := p.api.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return "", errors.Wrapf(appErr, "failed to get team with id %s", channel.TeamId)
+	}
+
+	return fmt.Sprintf("> [%s](/%s/pl/%s)%s", text, team.Name, post.Id, ellipsis), nil
+}
+
+// truncateQuote shortens text to at most length characters, cutting at the last word boundary
+// if there is one, and reports whether it was shortened. Line breaks are joined into a single
+// line so that the text fits in a quote.
+func truncateQuote(text string, length int) (string, bool) {
+	return truncate(strings.Join(strings.Fields(text), " "), length)
+}
+
+// truncate shortens text to at most length characters, cutting at the last word boundary if
+// there is one, and reports whether it was shortened.
+func truncate(text string, length int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return string(runes), false
+	}
+
+	cut := length
+	for i := length; i > 0; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace), true
+}
@@ -0,0 +1,65 @@
+package mailermost
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateQuote(t *testing.T) {
+	for name, test := range map[string]struct {
+		text      string
+		length    int
+		expected  string
+		truncated bool
+	}{
+		"short":              {text: "Lunch?", length: 10, expected: "Lunch?"},
+		"exact":              {text: "Lunch at noon", length: 13, expected: "Lunch at noon"},
+		"word boundary":      {text: "Lunch at noon today", length: 15, expected: "Lunch at noon", truncated: true},
+		"boundary at length": {text: "Lunch at noon today", length: 13, expected: "Lunch at noon", truncated: true},
+		"multi-byte":         {text: "Grüße aus Köln und Düsseldorf", length: 12, expected: "Grüße aus", truncated: true},
+		"single long word":   {text: "日本語のテキストです", length: 4, expected: "日本語の", truncated: true},
+		"line breaks":        {text: "Lunch\n\nat  noon", length: 20, expected: "Lunch at noon"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			text, truncated := truncateQuote(test.text, test.length)
+			assert.Equal(t, test.expected, text)
+			assert.Equal(t, test.truncated, truncated)
+		})
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	for mode, expected := range map[string][2]bool{
+		"":              {false, true},
+		QuoteOutOfOrder: {false, true},
+		QuoteAlways:     {true, true},
+		QuoteNever:      {false, false},
+	} {
+		p := &Poller{settings: Settings{QuoteMode: mode}}
+		assert.Equal(t, expected[0], p.quotes(true), mode)
+		assert.Equal(t, expected[1], p.quotes(false), mode)
+	}
+}
+
+func TestQuotePost(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetTeam", "team").Return(&model.Team{Name: "dev"}, nil)
+	p := &Poller{api: api, settings: Settings{QuoteLength: 10}}
+	post := &model.Post{Id: "post", Message: "Lunch at noon today?"}
+
+	quote, err := p.quotePost(post, &model.Channel{TeamId: "team"})
+	require.NoError(t, err)
+	assert.Equal(t, "> [Lunch at](/dev/pl/post)...", quote)
+
+	quote, err = p.quotePost(post, &model.Channel{Type: model.CHANNEL_DIRECT})
+	require.NoError(t, err)
+	assert.Equal(t, "> Lunch at...", quote)
+
+	quote, err = p.quotePost(&model.Post{Id: "post"}, &model.Channel{TeamId: "team"})
+	require.NoError(t, err)
+	assert.Empty(t, quote)
+}
@@ -0,0 +1,78 @@
+package mailermost
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// recipientHeaders are the headers checked for the address an email was delivered to.
+var recipientHeaders = []string{"Delivered-To", "X-Original-To", "To", "Cc"}
+
+// isAddressedTo reports whether any recipient header of an email contains the given address.
+func isAddressedTo(header mail.Header, address string) bool {
+	for _, recipient := range recipients(header) {
+		if strings.EqualFold(recipient, address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isForAccount reports whether an email is addressed to the account, to its address directly or
+// to one of its channel or direct message addresses.
+func isForAccount(header mail.Header, address string) bool {
+	return taggedRecipient(header, address, channelAddressTag) != "" ||
+		taggedRecipient(header, address, directAddressTag) != "" ||
+		isAddressedTo(header, address)
+}
+
+// taggedAddress adds a +tag to the local part of an address.
+func taggedAddress(address, tag string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+
+	return address[:at] + "+" + tag + address[at:]
+}
+
+// taggedRecipient returns what follows the tag prefix in the +tag of the first recipient that is
+// a tagged form of the given address, e.g. the token of a channel address, or an empty string.
+func taggedRecipient(header mail.Header, address, tagPrefix string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	prefix := strings.ToLower(address[:at] + "+" + tagPrefix)
+	domain := strings.ToLower(address[at:])
+
+	for _, recipient := range recipients(header) {
+		recipient = strings.ToLower(recipient)
+		if strings.HasPrefix(recipient, prefix) && strings.HasSuffix(recipient, domain) && len(recipient) > len(prefix)+len(domain) {
+			return recipient[len(prefix) : len(recipient)-len(domain)]
+		}
+	}
+
+	return ""
+}
+
+// recipients returns the addresses in the recipient headers of an email.
+func recipients(header mail.Header) []string {
+	var result []string
+	for _, key := range recipientHeaders {
+		for _, value := range header[key] {
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				// Delivered-To and X-Original-To usually hold a bare address.
+				addresses = []*mail.Address{{Address: strings.Trim(strings.TrimSpace(value), "<>")}}
+			}
+
+			for _, a := range addresses {
+				result = append(result, a.Address)
+			}
+		}
+	}
+
+	return result
+}
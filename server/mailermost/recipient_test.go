@@ -0,0 +1,49 @@
+package mailermost
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAddressedTo(t *testing.T) {
+	const address = "replies@example.org"
+
+	for name, tc := range map[string]struct {
+		header   mail.Header
+		expected bool
+	}{
+		"to":                  {mail.Header{"To": {"Mattermost <Replies@Example.org>"}}, true},
+		"cc among others":     {mail.Header{"To": {"a@example.org"}, "Cc": {"b@example.org, replies@example.org"}}, true},
+		"delivered-to":        {mail.Header{"To": {"list@example.org"}, "Delivered-To": {"replies@example.org"}}, true},
+		"bracketed original":  {mail.Header{"X-Original-To": {"<replies@example.org>"}}, true},
+		"other recipient":     {mail.Header{"To": {"someone@example.org"}}, false},
+		"no recipient header": {mail.Header{}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isAddressedTo(tc.header, address))
+		})
+	}
+}
+
+func TestTaggedRecipient(t *testing.T) {
+	const address = "replies@example.org"
+
+	assert.Equal(t, "abc123", taggedRecipient(mail.Header{"To": {"Team <Replies+ch-ABC123@example.org>"}}, address, channelAddressTag))
+	assert.Equal(t, "abc123", taggedRecipient(mail.Header{"To": {"a@example.org"}, "Delivered-To": {"replies+ch-abc123@example.org"}}, address, channelAddressTag))
+	assert.Equal(t, "", taggedRecipient(mail.Header{"To": {"replies@example.org"}}, address, channelAddressTag))
+	assert.Equal(t, "", taggedRecipient(mail.Header{"To": {"replies+ch-@example.org"}}, address, channelAddressTag))
+	assert.Equal(t, "", taggedRecipient(mail.Header{"To": {"replies+ch-abc123@example.com"}}, address, channelAddressTag))
+	assert.Equal(t, "replies+ch-abc123@example.org", ChannelAddress(address, "abc123"))
+}
+
+func TestIsForAccount(t *testing.T) {
+	const address = "replies@example.org"
+
+	assert.True(t, isForAccount(mail.Header{"To": {"replies@example.org"}}, address))
+	assert.True(t, isForAccount(mail.Header{"To": {"replies+ch-abc123@example.org"}}, address))
+	assert.True(t, isForAccount(mail.Header{"Cc": {"replies+dm-alice@example.org"}}, address))
+	assert.False(t, isForAccount(mail.Header{"To": {"replies+other@example.org"}}, address))
+	assert.False(t, isForAccount(mail.Header{"To": {"someone@example.org"}}, address))
+}
@@ -0,0 +1,83 @@
+package mailermost
+
+import (
+	"strings"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/pkg/errors"
+	"golang.org/x/net/idna"
+)
+
+// The headers that can identify the author of an email.
+const (
+	AuthorFrom    = "from"
+	AuthorSender  = "sender"
+	AuthorReplyTo = "reply-to"
+)
+
+// ValidateAuthorHeader checks the name of the header that identifies the author of emails. An
+// empty name means From.
+func ValidateAuthorHeader(header string) error {
+	switch header {
+	case "", AuthorFrom, AuthorSender, AuthorReplyTo:
+		return nil
+	default:
+		return errors.Errorf("unknown author header %q, expected %s, %s or %s", header, AuthorFrom, AuthorSender, AuthorReplyTo)
+	}
+}
+
+// senderAddress returns the address of the author of an email, as given by the configured
+// author header, falling back to From and then Sender if it is missing. The address is lower
+// case, with an internationalized domain in its Unicode form. It is empty if the email names no
+// author.
+func (p *Poller) senderAddress(envelope *imap.Envelope) string {
+	if envelope == nil {
+		return ""
+	}
+
+	candidates := [][]*imap.Address{envelope.From, envelope.Sender}
+	switch p.settings.AuthorHeader {
+	case AuthorSender:
+		candidates = [][]*imap.Address{envelope.Sender, envelope.From}
+	case AuthorReplyTo:
+		candidates = append([][]*imap.Address{envelope.ReplyTo}, candidates...)
+	}
+
+	for _, addresses := range candidates {
+		for _, address := range addresses {
+			// Group syntax in the header yields addresses without a host.
+			if address == nil || address.MailboxName == "" || address.HostName == "" {
+				continue
+			}
+			return normalizeAddress(address.MailboxName, address.HostName)
+		}
+	}
+
+	return ""
+}
+
+// normalizeAddress lower-cases an address and converts a punycode domain to Unicode.
+func normalizeAddress(mailbox, host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if unicode, err := idna.Lookup.ToUnicode(host); err == nil {
+		host = unicode
+	}
+
+	return strings.ToLower(strings.TrimSpace(mailbox)) + "@" + host
+}
+
+// asciiAddress returns the address with its domain in punycode, or an empty string if the domain
+// is already ASCII or cannot be converted.
+func asciiAddress(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+
+	host, err := idna.Lookup.ToASCII(address[at+1:])
+	if err != nil || host == address[at+1:] {
+		return ""
+	}
+
+	return address[:at+1] + host
+}
@@ -0,0 +1,37 @@
+package mailermost
+
+import (
+	"testing"
+
+	imap "github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSenderAddress(t *testing.T) {
+	from := []*imap.Address{{PersonalName: "First Last", MailboxName: "First.Last", HostName: "Example.COM"}}
+	sender := []*imap.Address{{MailboxName: "list", HostName: "lists.example.com"}}
+	replyTo := []*imap.Address{{MailboxName: "flast", HostName: "xn--bcher-kva.example"}}
+
+	for name, test := range map[string]struct {
+		header   string
+		envelope *imap.Envelope
+		expected string
+	}{
+		"from is case folded":       {envelope: &imap.Envelope{From: from, Sender: sender}, expected: "first.last@example.com"},
+		"sender":                    {header: AuthorSender, envelope: &imap.Envelope{From: from, Sender: sender}, expected: "list@lists.example.com"},
+		"reply-to in unicode":       {header: AuthorReplyTo, envelope: &imap.Envelope{From: from, ReplyTo: replyTo}, expected: "flast@bücher.example"},
+		"missing reply-to":          {header: AuthorReplyTo, envelope: &imap.Envelope{From: from}, expected: "first.last@example.com"},
+		"missing from":              {envelope: &imap.Envelope{Sender: sender}, expected: "list@lists.example.com"},
+		"no sender at all":          {envelope: &imap.Envelope{}, expected: ""},
+		"group syntax without host": {envelope: &imap.Envelope{From: []*imap.Address{{MailboxName: "undisclosed-recipients"}}}, expected: ""},
+		"no envelope":               {expected: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Poller{settings: Settings{AuthorHeader: test.header}}
+			assert.Equal(t, test.expected, p.senderAddress(test.envelope))
+		})
+	}
+
+	assert.Equal(t, "flast@xn--bcher-kva.example", asciiAddress("flast@bücher.example"))
+	assert.Equal(t, "", asciiAddress("flast@example.com"))
+}
@@ -0,0 +1,52 @@
+package mailermost
+
+import (
+	"time"
+)
+
+// Status describes the state of a poller.
+type Status struct {
+	Account string
+	Email   string
+	// LastPoll is the time the mailbox was last checked. It is zero if this node has not
+	// checked it yet, e.g. because another node in the cluster holds the poll lock.
+	LastPoll  time.Time
+	LastError string
+	// Paused is true while polling is paused after repeated login failures.
+	Paused  bool
+	Folders []FolderStatus
+}
+
+// Status returns the current state of the poller.
+func (p *Poller) Status() Status {
+	folders := p.FolderStatuses()
+
+	p.statusLock.RLock()
+	defer p.statusLock.RUnlock()
+
+	status := p.status
+	status.Account = p.account.Name
+	status.Email = p.account.Email
+	status.Folders = folders
+
+	return status
+}
+
+// setPollStatus records the outcome of checking the mailbox.
+func (p *Poller) setPollStatus(err error) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+
+	p.status.LastPoll = time.Now()
+	p.status.LastError = ""
+	if err != nil {
+		p.status.LastError = err.Error()
+	}
+}
+
+func (p *Poller) setPaused(paused bool) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+
+	p.status.Paused = paused
+}
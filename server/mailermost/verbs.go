@@ -0,0 +1,113 @@
+package mailermost
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// The actions of email commands.
+const (
+	// ActionReact adds a reaction to the post replied to.
+	ActionReact = "react"
+	// ActionFollow emails the user about new replies in the thread replied to.
+	ActionFollow = "follow"
+	// ActionUnfollow stops emailing the user about new replies in the thread replied to.
+	ActionUnfollow = "unfollow"
+	// ActionFlag flags the post replied to for the user.
+	ActionFlag = "flag"
+)
+
+// emojiNameRe matches the emoji names accepted in reactions.
+var emojiNameRe = regexp.MustCompile(`^[a-zA-Z0-9\-\+_]+$`)
+
+// EmailCommand is an action taken on the post replied to instead of posting the reply, if the
+// reply consists of nothing but its verb, e.g. +1 or /follow.
+type EmailCommand struct {
+	Action string
+	// Emoji is the name of the emoji added by ActionReact, e.g. +1.
+	Emoji string
+}
+
+// ParseEmailCommands parses lines like "+1 react +1" or "/follow follow", made of a verb, an
+// action and the emoji name of react actions, into a map of the lower case verbs to their
+// command.
+func ParseEmailCommands(text string) (map[string]EmailCommand, error) {
+	commands := make(map[string]EmailCommand)
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, errors.Errorf("invalid email command %q, expected a verb and an action", strings.TrimSpace(line))
+		}
+
+		verb := strings.ToLower(fields[0])
+		command := EmailCommand{Action: strings.ToLower(fields[1])}
+		switch command.Action {
+		case ActionReact:
+			if len(fields) != 3 {
+				return nil, errors.Errorf("invalid email command %q, expected the name of an emoji to react with", verb)
+			}
+			command.Emoji = strings.Trim(fields[2], ":")
+			if len(command.Emoji) > model.EMOJI_NAME_MAX_LENGTH || !emojiNameRe.MatchString(command.Emoji) {
+				return nil, errors.Errorf("invalid emoji %q of email command %q", fields[2], verb)
+			}
+		case ActionFollow, ActionUnfollow, ActionFlag:
+			if len(fields) != 2 {
+				return nil, errors.Errorf("invalid email command %q, %s takes no arguments", verb, command.Action)
+			}
+		default:
+			return nil, errors.Errorf("invalid email command %q, unknown action %q", verb, command.Action)
+		}
+
+		if _, ok := commands[verb]; ok {
+			return nil, errors.Errorf("duplicate email command %q", verb)
+		}
+		commands[verb] = command
+	}
+
+	return commands, nil
+}
+
+// emailCommand returns the command the reply text consists of, if any.
+func (p *Poller) emailCommand(text string) (EmailCommand, bool) {
+	command, ok := p.settings.EmailCommands[strings.ToLower(strings.TrimSpace(text))]
+	return command, ok
+}
+
+// runCommand takes the action of an email command on the post replied to, or the root post of
+// its thread.
+func (p *Poller) runCommand(user *model.User, post, rootPost *model.Post, command EmailCommand) error {
+	switch command.Action {
+	case ActionReact:
+		if _, appErr := p.api.AddReaction(&model.Reaction{
+			UserId:    user.Id,
+			PostId:    post.Id,
+			EmojiName: command.Emoji,
+		}); appErr != nil {
+			return errors.Wrapf(appErr, "failed to add reaction %s to post %s", command.Emoji, post.Id)
+		}
+	case ActionFollow:
+		return FollowThread(p.api, rootPost.Id, user.Id)
+	case ActionUnfollow:
+		return UnfollowThread(p.api, rootPost.Id, user.Id)
+	case ActionFlag:
+		// Flags are stored in the preferences of the user, like the webapp does.
+		if appErr := p.api.UpdatePreferencesForUser(user.Id, []model.Preference{{
+			UserId:   user.Id,
+			Category: model.PREFERENCE_CATEGORY_FLAGGED_POST,
+			Name:     post.Id,
+			Value:    "true",
+		}}); appErr != nil {
+			return errors.Wrapf(appErr, "failed to flag post %s", post.Id)
+		}
+	default:
+		return errors.Errorf("unknown email command action %q", command.Action)
+	}
+
+	return nil
+}
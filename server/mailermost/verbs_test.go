@@ -0,0 +1,64 @@
+package mailermost
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEmailCommands(t *testing.T) {
+	commands, err := ParseEmailCommands("+1 react +1\n:ThumbsUp: react :thumbsup:\n\n/Follow follow\n/flag flag")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]EmailCommand{
+		"+1":         {Action: ActionReact, Emoji: "+1"},
+		":thumbsup:": {Action: ActionReact, Emoji: "thumbsup"},
+		"/follow":    {Action: ActionFollow},
+		"/flag":      {Action: ActionFlag},
+	}, commands)
+
+	for _, text := range []string{
+		"+1",
+		"+1 react",
+		"+1 react not/an/emoji",
+		"/follow follow now",
+		"/flag flag it",
+		"/star star",
+		"+1 react +1\n+1 react heart",
+	} {
+		_, err = ParseEmailCommands(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestEmailCommand(t *testing.T) {
+	p := &Poller{settings: Settings{EmailCommands: map[string]EmailCommand{"/follow": {Action: ActionFollow}}}}
+
+	command, ok := p.emailCommand(" /FOLLOW\n")
+	assert.True(t, ok)
+	assert.Equal(t, ActionFollow, command.Action)
+
+	_, ok = p.emailCommand("/follow the money")
+	assert.False(t, ok)
+}
+
+func TestRunCommandFlag(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := &Poller{api: api}
+
+	user := &model.User{Id: "user"}
+	post := &model.Post{Id: "reply", RootId: "root"}
+	rootPost := &model.Post{Id: "root"}
+
+	api.On("UpdatePreferencesForUser", "user", []model.Preference{{
+		UserId:   "user",
+		Category: model.PREFERENCE_CATEGORY_FLAGGED_POST,
+		Name:     "reply",
+		Value:    "true",
+	}}).Return(nil).Once()
+
+	require.NoError(t, p.runCommand(user, post, rootPost, EmailCommand{Action: ActionFlag}))
+}
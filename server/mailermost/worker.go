@@ -0,0 +1,122 @@
+package mailermost
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"io/ioutil"
+	"net/mail"
+	"sync"
+
+	imap "github.com/emersion/go-imap"
+)
+
+// processingWorkers bounds the number of emails processed in parallel.
+const processingWorkers = 4
+
+// inboundEmail is an email fetched from the mailbox, waiting to be processed.
+type inboundEmail struct {
+	// uid identifies the email in its folder for deleting it, as sequence numbers change when
+	// other clients expunge emails.
+	uid      uint32
+	envelope *imap.Envelope
+	raw      []byte
+}
+
+// processEmails processes the given emails on a bounded pool of workers and returns the UIDs of
+// the emails to delete from the mailbox.
+//
+// Emails replying to the same thread are always handed to the same worker, in the order they
+// were fetched, so replies keep their order within a thread. Once ctx is canceled, the workers
+// finish the email they are processing and skip the rest.
+func (p *Poller) processEmails(ctx context.Context, emails []*inboundEmail) []uint32 {
+	return p.runWorkers(ctx, emails, p.processEmail)
+}
+
+// runWorkers hands the emails to the workers, which process each of them with process.
+func (p *Poller) runWorkers(ctx context.Context, emails []*inboundEmail, process func(email *inboundEmail) outcome) []uint32 {
+	queues := make([][]*inboundEmail, processingWorkers)
+	rootIDs := make(map[string]string)
+	for _, email := range emails {
+		worker := p.threadKey(email, rootIDs) % processingWorkers
+		queues[worker] = append(queues[worker], email)
+	}
+
+	var (
+		lock    sync.Mutex
+		deletes []uint32
+		wg      sync.WaitGroup
+	)
+	for _, queue := range queues {
+		if len(queue) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(queue []*inboundEmail) {
+			defer wg.Done()
+
+			for _, email := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+
+				o := process(email)
+				p.audit(email.envelope, o)
+				if o.deletes() {
+					lock.Lock()
+					deletes = append(deletes, email.uid)
+					lock.Unlock()
+				}
+			}
+		}(queue)
+	}
+	wg.Wait()
+
+	return deletes
+}
+
+// threadKey returns a hash of the root post of the thread an email replies to. rootIDs caches
+// the root post IDs looked up so far. Emails that do not reference a post, e.g. those starting
+// new posts, are keyed on their Message-ID instead, so that they are spread over the workers.
+func (p *Poller) threadKey(email *inboundEmail, rootIDs map[string]string) uint32 {
+	key := email.envelope.MessageId
+	if _, body, err := parseEmail(email.raw); err == nil {
+		if postID, postIDErr := p.postIDFromEmailBody(string(body)); postIDErr == nil {
+			key = p.rootID(postID, rootIDs)
+		}
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func (p *Poller) rootID(postID string, rootIDs map[string]string) string {
+	if rootID, ok := rootIDs[postID]; ok {
+		return rootID
+	}
+
+	rootID := postID
+	if post, appErr := p.api.GetPost(postID); appErr == nil && post.RootId != "" {
+		rootID = post.RootId
+	}
+	rootIDs[postID] = rootID
+
+	return rootID
+}
+
+// parseEmail splits a raw email into its header and body.
+func parseEmail(raw []byte) (mail.Header, []byte, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m.Header, body, nil
+}
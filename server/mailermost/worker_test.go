@@ -0,0 +1,107 @@
+package mailermost
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunWorkers(t *testing.T) {
+	rootID, replyID := model.NewId(), model.NewId()
+
+	api := &plugintest.API{}
+	p := newTestPoller(t, api)
+	p.auditLog = NewAuditLog(api)
+	api.On("GetPost", rootID).Return(&model.Post{Id: rootID}, nil)
+	api.On("GetPost", replyID).Return(&model.Post{Id: replyID, RootId: rootID}, nil)
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+
+	// Replies to the root post of a thread and to a reply in it alternate with new posts.
+	var emails []*inboundEmail
+	thread := make(map[uint32]bool)
+	for uid := uint32(1); uid <= 12; uid++ {
+		if uid%2 == 1 {
+			emails = append(emails, testEmail(uid, "replies+ch-abc123@example.org", "", "New post"))
+			continue
+		}
+
+		postID := rootID
+		if uid%4 == 0 {
+			postID = replyID
+		}
+		emails = append(emails, testEmail(uid, "replies@example.org", "", "Reply\r\n\r\n> https://chat.example.org/team/pl/"+postID))
+		thread[uid] = true
+	}
+
+	outcomes := map[uint32]outcome{
+		1:  outcome{}.retry(ReasonInternalError),
+		3:  outcome{}.reject(ReasonUnknownUser),
+		5:  {result: ResultDuplicate},
+		7:  {result: ResultIgnored, reason: ReasonNotAddressed},
+		9:  {result: ResultPosted},
+		11: outcome{}.retry(ReasonPostFailed),
+	}
+
+	var (
+		lock     sync.Mutex
+		order    []uint32
+		inThread int32
+		overlap  int32
+	)
+	deletes := p.runWorkers(context.Background(), emails, func(email *inboundEmail) outcome {
+		if !thread[email.uid] {
+			return outcomes[email.uid]
+		}
+
+		if atomic.AddInt32(&inThread, 1) > 1 {
+			atomic.StoreInt32(&overlap, 1)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inThread, -1)
+
+		lock.Lock()
+		order = append(order, email.uid)
+		lock.Unlock()
+		return outcome{result: ResultPosted}
+	})
+
+	assert.Equal(t, int32(0), overlap, "replies in one thread were processed in parallel")
+	assert.Equal(t, []uint32{2, 4, 6, 8, 10, 12}, order)
+
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i] < deletes[j] })
+	assert.Equal(t, []uint32{2, 3, 4, 5, 6, 8, 9, 10, 12}, deletes)
+}
+
+func TestThreadKey(t *testing.T) {
+	rootID, replyID := model.NewId(), model.NewId()
+
+	api := &plugintest.API{}
+	p := newTestPoller(t, api)
+	api.On("GetPost", rootID).Return(&model.Post{Id: rootID}, nil).Once()
+	api.On("GetPost", replyID).Return(&model.Post{Id: replyID, RootId: rootID}, nil).Once()
+
+	rootIDs := make(map[string]string)
+	reply := func(uid uint32, postID string) *inboundEmail {
+		return testEmail(uid, "replies@example.org", "", "Reply\r\n\r\n> https://chat.example.org/team/pl/"+postID)
+	}
+
+	key := p.threadKey(reply(1, rootID), rootIDs)
+	assert.Equal(t, key, p.threadKey(reply(2, replyID), rootIDs))
+	assert.Equal(t, key, p.threadKey(reply(3, rootID), rootIDs))
+
+	// Emails starting new posts are spread over the workers.
+	workers := make(map[uint32]bool)
+	for uid := uint32(1); uid <= 20; uid++ {
+		workers[p.threadKey(testEmail(uid, "replies+ch-abc123@example.org", "", "New post"), rootIDs)%processingWorkers] = true
+	}
+	assert.Len(t, workers, processingWorkers)
+}
@@ -12,7 +12,29 @@ import (
 type Plugin struct {
 	plugin.MattermostPlugin
 
-	Poller *mailermost.Poller
+	// Pollers poll the mailbox of each configured account.
+	Pollers []*mailermost.Poller
+
+	// botUserID is the ID of the bot that plugin notices are sent from.
+	botUserID string
+
+	// metrics counts what the pollers do. It outlives the pollers, which are recreated whenever
+	// the configuration changes.
+	metrics *mailermost.Metrics
+
+	// restartLock serializes starting and stopping the pollers. It is held while waiting for
+	// the pollers to stop, so it must not be taken by anything else.
+	restartLock sync.Mutex
+
+	// pollerLock synchronizes access to the running pollers.
+	pollerLock sync.Mutex
+
+	// stopPollers cancels the running pollers and waits for them to return. It is nil while no
+	// pollers are running.
+	stopPollers func()
+
+	// followedThreads tells which threads have users following them by email.
+	followedThreads *mailermost.FollowedThreads
 
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
@@ -20,4 +42,8 @@ type Plugin struct {
 	// configuration is the active plugin configuration. Consult getConfiguration and
 	// setConfiguration for usage.
 	configuration *configuration
+
+	// unfollowVerb is a verb of the unfollow email command of the active configuration, or an
+	// empty string. It is set along with the configuration.
+	unfollowVerb string
 }
@@ -0,0 +1,14 @@
+module.exports = {
+    presets: [
+        ['@babel/preset-env', {
+            targets: {
+                chrome: 66,
+                firefox: 60,
+                edge: 42,
+                safari: 12,
+            },
+            modules: process.env.NODE_ENV === 'test' ? 'commonjs' : false,
+        }],
+        '@babel/preset-react',
+    ],
+};
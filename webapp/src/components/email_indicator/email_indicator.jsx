@@ -0,0 +1,33 @@
+import React from 'react';
+import PropTypes from 'prop-types';
+
+import {indicatorTitle, isFromEmail} from '../../post';
+
+const style = {
+    display: 'inline-block',
+    marginTop: '2px',
+    fontSize: '11px',
+    opacity: 0.6,
+};
+
+// EmailIndicator marks posts created from emails, with the DMARC result of the email as its
+// tooltip.
+export default function EmailIndicator({post}) {
+    if (!isFromEmail(post)) {
+        return null;
+    }
+
+    return (
+        <span
+            className='mailermost-via-email'
+            style={style}
+            title={indicatorTitle(post)}
+        >
+            {'via email'}
+        </span>
+    );
+}
+
+EmailIndicator.propTypes = {
+    post: PropTypes.object,
+};
@@ -0,0 +1,12 @@
+import {connect} from 'react-redux';
+import {getPost} from 'mattermost-redux/selectors/entities/posts';
+
+import EmailIndicator from './email_indicator';
+
+function mapStateToProps(state, ownProps) {
+    return {
+        post: getPost(state, ownProps.postId),
+    };
+}
+
+export default connect(mapStateToProps)(EmailIndicator);
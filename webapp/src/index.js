@@ -0,0 +1,12 @@
+import {id as pluginId} from './manifest';
+import EmailIndicator from './components/email_indicator';
+
+export default class Plugin {
+    initialize(registry) {
+        // The webapp has no slot next to the post timestamp, so the indicator is rendered
+        // below the message.
+        registry.registerPostMessageAttachmentComponent(EmailIndicator);
+    }
+}
+
+window.registerPlugin(pluginId, new Plugin());
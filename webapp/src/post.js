@@ -0,0 +1,21 @@
+// The props the server sets on posts created from emails.
+export const PROP_SOURCE = 'source';
+export const PROP_VERIFICATION = 'email_verification';
+
+export const SOURCE_EMAIL = 'email';
+export const VERIFICATION_NONE = 'none';
+
+// isFromEmail reports whether the post was created from an email.
+export function isFromEmail(post) {
+    return Boolean(post && post.props && post.props[PROP_SOURCE] === SOURCE_EMAIL);
+}
+
+// indicatorTitle describes how the post was created, including the DMARC result of the email.
+export function indicatorTitle(post) {
+    const verification = (post.props && post.props[PROP_VERIFICATION]) || VERIFICATION_NONE;
+    if (verification === VERIFICATION_NONE) {
+        return 'Posted by replying to an email. The sender could not be verified with DMARC.';
+    }
+
+    return `Posted by replying to an email. DMARC result: ${verification}.`;
+}
@@ -0,0 +1,26 @@
+import {indicatorTitle, isFromEmail} from './post';
+
+describe('isFromEmail', () => {
+    test('posts created from emails', () => {
+        expect(isFromEmail({props: {source: 'email'}})).toBe(true);
+    });
+
+    test('other posts', () => {
+        expect(isFromEmail({props: {source: 'slack'}})).toBe(false);
+        expect(isFromEmail({props: {}})).toBe(false);
+        expect(isFromEmail({})).toBe(false);
+        expect(isFromEmail(null)).toBe(false);
+    });
+});
+
+describe('indicatorTitle', () => {
+    test('with a DMARC result', () => {
+        expect(indicatorTitle({props: {source: 'email', email_verification: 'pass'}})).toBe('Posted by replying to an email. DMARC result: pass.');
+    });
+
+    test('without a DMARC result', () => {
+        const expected = 'Posted by replying to an email. The sender could not be verified with DMARC.';
+        expect(indicatorTitle({props: {source: 'email', email_verification: 'none'}})).toBe(expected);
+        expect(indicatorTitle({props: {source: 'email'}})).toBe(expected);
+    });
+});